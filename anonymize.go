@@ -0,0 +1,60 @@
+package ephemeris
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// The ways in which the IP addresses of comment-submitters may be
+// anonymised, when comments are loaded.
+const (
+	// AnonymizeNone leaves addresses unchanged, which is the default.
+	AnonymizeNone = "none"
+
+	// AnonymizeTruncate zeros the host-part of each address, keeping
+	// the first three octets of IPv4 addresses, and the first 48 bits
+	// of IPv6 addresses.
+	AnonymizeTruncate = "truncate"
+
+	// AnonymizeHash replaces each address with a keyed hash, so that
+	// comments from the same address may still be grouped together.
+	//
+	// The key is a secret, without which the hashes of all the
+	// possible addresses could be computed, and matched.
+	AnonymizeHash = "hash"
+)
+
+// AnonymizeIP anonymises the given IP address, in the given mode, using
+// the given secret as the key of the hash when hashing.
+//
+// Values which are not IP addresses are hashed, or removed when
+// truncating, since we cannot tell which part of them is private.
+func AnonymizeIP(mode string, secret string, ip string) string {
+
+	ip = strings.TrimSpace(ip)
+	if ip == "" {
+		return ""
+	}
+
+	switch mode {
+	case AnonymizeTruncate:
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return ""
+		}
+		if v4 := addr.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return addr.Mask(net.CIDRMask(48, 128)).String()
+
+	case AnonymizeHash:
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write([]byte(ip))
+		return fmt.Sprintf("%x", h.Sum(nil)[:8])
+	}
+
+	return ip
+}
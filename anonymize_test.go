@@ -0,0 +1,60 @@
+package ephemeris
+
+import (
+	"testing"
+)
+
+// Test the anonymisation of IP addresses.
+func TestAnonymizeIP(t *testing.T) {
+
+	type TestCase struct {
+		mode   string
+		input  string
+		output string
+	}
+
+	tests := []TestCase{
+		{"", "192.0.2.15", "192.0.2.15"},
+		{AnonymizeNone, "192.0.2.15", "192.0.2.15"},
+		{AnonymizeTruncate, "192.0.2.15", "192.0.2.0"},
+		{AnonymizeTruncate, "2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{AnonymizeTruncate, "::ffff:192.0.2.15", "192.0.2.0"},
+		{AnonymizeTruncate, "not an address", ""},
+		{AnonymizeTruncate, "", ""},
+		{AnonymizeHash, "", ""},
+	}
+
+	for _, tst := range tests {
+		out := AnonymizeIP(tst.mode, "salt", tst.input)
+		if out != tst.output {
+			t.Errorf("anonymising %s with '%s' gave '%s' not '%s'", tst.input, tst.mode, out, tst.output)
+		}
+	}
+
+	// Hashes are stable, and salted.
+	a := AnonymizeIP(AnonymizeHash, "salt", "192.0.2.15")
+	b := AnonymizeIP(AnonymizeHash, "salt", "192.0.2.15")
+	c := AnonymizeIP(AnonymizeHash, "pepper", "192.0.2.15")
+	if a != b || a == c || len(a) != 16 || a == "192.0.2.15" {
+		t.Errorf("unexpected hashes %s %s %s", a, b, c)
+	}
+}
+
+// Test that hashing addresses requires a secret.
+func TestAnonymizeSecret(t *testing.T) {
+
+	_, err := NewWithOptions("", "", "", Options{AnonymizeIP: AnonymizeHash})
+	if err == nil {
+		t.Errorf("expected an error without a secret")
+	}
+
+	_, err = NewWithOptions("", "", "", Options{AnonymizeIP: AnonymizeHash, AnonymizeSecret: "secret"})
+	if err != nil {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+
+	// The hash is keyed, rather than salted.
+	if AnonymizeIP(AnonymizeHash, "secret", "192.0.2.15") != "66a6885391361a37" {
+		t.Errorf("unexpected hash %s", AnonymizeIP(AnonymizeHash, "secret", "192.0.2.15"))
+	}
+}
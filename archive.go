@@ -0,0 +1,179 @@
+package ephemeris
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveFormats maps the extensions of the archives we can write to
+// their format.
+var archiveFormats = map[string]string{
+	".tar":    "tar",
+	".tar.gz": "tar.gz",
+	".tgz":    "tar.gz",
+	".zip":    "zip",
+}
+
+// archiveFormat returns the format of the archive with the given name,
+// or the empty string if it isn't an archive we can write.
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	for ext, format := range archiveFormats {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return format
+		}
+	}
+	return ""
+}
+
+// IsArchive returns true if the given filename has the extension of an
+// archive which can be written via NewArchive, such as "site.tar.gz".
+func IsArchive(name string) bool {
+	return archiveFormat(name) != ""
+}
+
+// Archive is an OutputFS which writes a site into an archive, such as a
+// tarball, which may then be deployed directly.
+//
+// Each file is written to the archive when it is closed.  The archive
+// itself must be closed once the site has been written, to complete it.
+type Archive struct {
+	mu    sync.Mutex
+	tar   *tar.Writer
+	gzip  *gzip.Writer
+	zip   *zip.Writer
+	names map[string]bool
+
+	// modTime is the modification time given to each file.
+	modTime time.Time
+}
+
+// NewArchive returns an Archive which writes to the given writer.
+//
+// The format is chosen by the extension of the given name, which may be
+// ".tar", ".tar.gz", ".tgz", or ".zip".
+func NewArchive(w io.Writer, name string) (*Archive, error) {
+
+	a := &Archive{names: make(map[string]bool), modTime: time.Now()}
+
+	switch archiveFormat(name) {
+	case "tar":
+		a.tar = tar.NewWriter(w)
+	case "tar.gz":
+		a.gzip = gzip.NewWriter(w)
+		a.tar = tar.NewWriter(a.gzip)
+	case "zip":
+		a.zip = zip.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("%s is not a .tar, .tar.gz, .tgz, or .zip archive", name)
+	}
+	return a, nil
+}
+
+// archiveWriter collects the contents of a file, which is written to the
+// archive when it is closed, since the size of each file precedes it.
+type archiveWriter struct {
+	bytes.Buffer
+	archive *Archive
+	name    string
+}
+
+// Close writes the file to the archive.
+func (w *archiveWriter) Close() error {
+	return w.archive.add(w.name, w.Bytes(), "")
+}
+
+// Create creates the named file, which fails if the archive already
+// holds a file of that name.
+func (a *Archive) Create(name string) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	return &archiveWriter{archive: a, name: name}, nil
+}
+
+// Symlink adds the named symlink, which fails if the archive already
+// holds a file of that name.
+func (a *Archive) Symlink(target string, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return a.add(name, nil, target)
+}
+
+// add writes a single file, or symlink if link is set, to the archive.
+func (a *Archive) add(name string, data []byte, link string) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.names[name] {
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	a.names[name] = true
+
+	if a.zip != nil {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.modTime}
+		header.SetMode(0644)
+		if link != "" {
+			header.Method = zip.Store
+			header.SetMode(fs.ModeSymlink | 0777)
+			data = []byte(link)
+		}
+
+		w, err := a.zip.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  a.modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if link != "" {
+		header.Mode = 0777
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = link
+	}
+
+	err := a.tar.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = a.tar.Write(data)
+	return err
+}
+
+// Close completes the archive.  It doesn't close the underlying writer.
+func (a *Archive) Close() error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+
+	err := a.tar.Close()
+	if err != nil {
+		return err
+	}
+	if a.gzip != nil {
+		return a.gzip.Close()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package ephemeris
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// The avatar-providers which may be used for comment-submitters.
+const (
+	// AvatarNone means no icon is shown for comments.
+	AvatarNone = "none"
+
+	// AvatarGravatar means icons are loaded from gravatar.com, which
+	// is the default.
+	AvatarGravatar = "gravatar"
+
+	// AvatarIdenticon means icons are generated locally, as SVG
+	// images, from a salted hash of the submitter's email address.
+	AvatarIdenticon = "identicon"
+)
+
+// AvatarDirectory is the directory, relative to the output-path and
+// the URL-prefix, in which identicons are stored.
+const AvatarDirectory = "avatars"
+
+// avatarID returns the (salted) hash of an email-address, which is used
+// to name a locally generated identicon.
+//
+// The same address will always result in the same ID, so long as the
+// salt is unchanged, but the address cannot be recovered from it.
+func avatarID(salt string, mail string) string {
+	m := strings.ToLower(strings.TrimSpace(mail))
+	h := sha256.Sum256([]byte(salt + m))
+	return fmt.Sprintf("%x", h[:16])
+}
+
+// avatarIcon returns the URL of the icon to be shown for the given
+// email-address, using the avatar-provider from the site-options.
+//
+// If an identicon is being used the ID of the icon is returned too,
+// otherwise that will be empty.
+func avatarIcon(site *Ephemeris, mail string) (string, string) {
+
+	// Default to gravatar, as that was our historical behaviour.
+	provider := AvatarGravatar
+	if site != nil && site.Options.Avatars != "" {
+		provider = site.Options.Avatars
+	}
+
+	switch provider {
+	case AvatarNone:
+		return "", ""
+	case AvatarIdenticon:
+		id := avatarID(site.Options.AvatarSalt, mail)
+		return site.Prefix + AvatarDirectory + "/" + id + ".svg", id
+	default:
+		m := strings.ToLower(mail)
+		h := fmt.Sprintf("%x", md5.Sum([]byte(m)))
+		return "//www.gravatar.com/avatar/" + h + ";size=32", ""
+	}
+}
+
+// Identicon generates a simple SVG image from the given avatar-ID.
+//
+// The image is a symmetrical 5x5 grid of cells, with the colour and
+// the pattern both derived from the ID, so the output is deterministic
+// and requires no network access.
+func Identicon(id string) string {
+
+	sum := sha256.Sum256([]byte(id))
+
+	// Pick a hue from the first two bytes.
+	hue := (int(sum[0])<<8 | int(sum[1])) % 360
+
+	var out strings.Builder
+	out.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="32" height="32" viewBox="0 0 5 5" shape-rendering="crispEdges">`)
+	out.WriteString(`<rect width="5" height="5" fill="#f0f0f0"/>`)
+
+	//
+	// We only decide upon the left three columns, and mirror
+	// them to the right.
+	//
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 3; x++ {
+			if sum[2+y*3+x]&1 == 0 {
+				continue
+			}
+			fill := fmt.Sprintf(`fill="hsl(%d, 55%%, 50%%)"`, hue)
+			out.WriteString(fmt.Sprintf(`<rect x="%d" y="%d" width="1" height="1" %s/>`, x, y, fill))
+			if x != 2 {
+				out.WriteString(fmt.Sprintf(`<rect x="%d" y="%d" width="1" height="1" %s/>`, 4-x, y, fill))
+			}
+		}
+	}
+	out.WriteString("</svg>\n")
+
+	return out.String()
+}
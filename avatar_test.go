@@ -0,0 +1,93 @@
+package ephemeris
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test the avatar-ID is stable, salted, and case-insensitive.
+func TestAvatarID(t *testing.T) {
+
+	a := avatarID("salt", "steve@example.com")
+	b := avatarID("salt", " Steve@Example.COM ")
+	if a != b {
+		t.Errorf("avatar IDs differ for the same address: %s != %s", a, b)
+	}
+
+	c := avatarID("pepper", "steve@example.com")
+	if a == c {
+		t.Errorf("avatar IDs should differ with different salts")
+	}
+}
+
+// Test the identicons we generate look sane.
+func TestIdenticon(t *testing.T) {
+
+	a := Identicon("1234")
+	if !strings.HasPrefix(a, "<svg") {
+		t.Errorf("identicon doesn't look like an SVG image: %s", a)
+	}
+
+	if a != Identicon("1234") {
+		t.Errorf("identicons are not deterministic")
+	}
+	if a == Identicon("5678") {
+		t.Errorf("different IDs resulted in the same identicon")
+	}
+}
+
+// Test the avatar-providers are used for comments.
+func TestAvatarProviders(t *testing.T) {
+
+	// Gravatar is the default
+	site, err := New("", "", "https://example.com/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+	c, err := loadBlogComment("_test/blog_comment/valid.html.12345", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if !strings.Contains(c.Icon, "gravatar.com") || c.AvatarID != "" {
+		t.Errorf("unexpected icon for default-provider: %s", c.Icon)
+	}
+
+	// No icons
+	site, err = NewWithOptions("", "", "https://example.com/", Options{Avatars: AvatarNone})
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+	c, err = loadBlogComment("_test/blog_comment/valid.html.12345", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if c.Icon != "" || c.AvatarID != "" {
+		t.Errorf("unexpected icon when avatars are disabled: %s", c.Icon)
+	}
+
+	// Identicons require a salt
+	_, err = NewWithOptions("", "", "https://example.com/", Options{Avatars: AvatarIdenticon})
+	if err == nil {
+		t.Errorf("expected an error using identicons without a salt")
+	}
+
+	// Identicons
+	site, err = NewWithOptions("", "", "https://example.com/", Options{Avatars: AvatarIdenticon, AvatarSalt: "salt"})
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+	c, err = loadBlogComment("_test/blog_comment/valid.html.12345", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	expected := "https://example.com/avatars/" + avatarID("salt", "steve@example.com") + ".svg"
+	if c.Icon != expected {
+		t.Errorf("unexpected identicon link: %s != %s", c.Icon, expected)
+	}
+
+	// Bogus provider
+	_, err = NewWithOptions("", "", "https://example.com/", Options{Avatars: "bogus"})
+	if err == nil {
+		t.Fatalf("expected an error with an unknown avatar-provider")
+	}
+}
@@ -1,16 +1,89 @@
 package ephemeris
 
 import (
-	"crypto/md5"
-	"fmt"
-	"path/filepath"
-	"strconv"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/shurcooL/github_flavored_markdown"
 	"github.com/skx/headerfile"
+	xhtml "golang.org/x/net/html"
 )
 
+// commentRel is the rel-attribute of every link within a comment, which
+// marks it as user-generated content which mustn't be followed.
+const commentRel = "nofollow ugc"
+
+// commentPolicy is the HTML sanitizer applied to every comment-body.
+//
+// Comments are user-submitted, so we only allow a small set of
+// formatting tags and attributes through, and we mark every link
+// as nofollow.  The only rel-attribute allowed upon links is the
+// one markLinks adds.
+var commentPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(true)
+	p.AllowAttrs("rel").Matching(regexp.MustCompile("^" + commentRel + "$")).OnElements("a")
+	return p
+}()
+
+// renderCommentBody converts the (markdown) body of a comment to HTML,
+// and sanitizes the result.
+//
+// Comments which were converted to HTML at submission-time will
+// pass through the markdown-conversion unchanged, and regardless of
+// their origin any scripts, event-handlers, and similar content will
+// be removed.
+func renderCommentBody(body string) string {
+
+	// Convert from markdown to HTML.
+	out := github_flavored_markdown.Markdown([]byte(body))
+
+	// Mark every link as user-generated content, then apply our
+	// allow-list of tags & attributes.
+	return commentPolicy.Sanitize(markLinks(string(out)))
+}
+
+// markLinks replaces the rel-attribute of every link within the given
+// HTML with commentRel.
+func markLinks(content string) string {
+
+	var out strings.Builder
+
+	tokens := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := tokens.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+
+		raw := string(tokens.Raw())
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			out.WriteString(raw)
+			continue
+		}
+
+		tok := tokens.Token()
+		if tok.Data != "a" {
+			out.WriteString(raw)
+			continue
+		}
+
+		attrs := []xhtml.Attribute{}
+		for _, attr := range tok.Attr {
+			if attr.Key != "rel" {
+				attrs = append(attrs, attr)
+			}
+		}
+		tok.Attr = append(attrs, xhtml.Attribute{Key: "rel", Val: commentRel})
+		out.WriteString(tok.String())
+	}
+
+	return out.String()
+}
+
 // BlogComment is the structure to describe a comment associated with a
 // blog post.
 type BlogComment struct {
@@ -18,16 +91,45 @@ type BlogComment struct {
 	// Author holds the name of the comment-submitter.
 	Author string
 
+	// Email holds the email-address of the comment-submitter.
+	//
+	// This is private, and is not shown by the default theme.
+	Email string
+
+	// IP holds the address the comment was submitted from, which
+	// will have been anonymised if the site's options request that.
+	IP string
+
+	// UserAgent holds the user-agent of the comment-submitter.
+	UserAgent string
+
+	// Headers holds every header from the comment-file, including
+	// those we don't otherwise use.  The keys are lower-cased.
+	Headers map[string]string
+
 	// Body holds the body of the comment.
+	//
+	// The body is treated as markdown, and will have been converted
+	// to sanitized HTML when the comment was loaded.
 	Body string
 
 	// Icon is generated from the email-address of the submitter.
-	// This will be a gravitar link.
+	//
+	// This will be a gravatar link, a link to a locally generated
+	// identicon, or empty, depending upon the avatar-provider in use.
 	Icon string
 
+	// AvatarID holds the ID of the locally generated identicon for
+	// this comment, if identicons are in use.
+	AvatarID string
+
 	// Link holds any user-submitted URL.
 	Link string
 
+	// Type holds the type of the comment, which is empty for
+	// regular comments, or "webmention" for received webmentions.
+	Type string
+
 	// Date is when the comment was created - this is extracted
 	// from the filename of the comment file.
 	//
@@ -37,7 +139,16 @@ type BlogComment struct {
 
 // NewBlogComment reads a comment from the named file, and returns that
 // in a structured form.
+//
+// The icon for the comment will be a gravatar link, use the avatar
+// settings of a site to change that.
 func NewBlogComment(path string) (BlogComment, error) {
+	return loadBlogComment(path, nil)
+}
+
+// loadBlogComment reads a comment from the named file, using the
+// options of the given site, which may be nil.
+func loadBlogComment(path string, site *Ephemeris) (BlogComment, error) {
 
 	// The result
 	var result BlogComment
@@ -58,11 +169,21 @@ func NewBlogComment(path string) (BlogComment, error) {
 		return result, err
 	}
 
+	//
+	// Anonymise the IP address, if required.
+	//
+	if ip, ok := headers["ip-address"]; ok && site != nil {
+		headers["ip-address"] = AnonymizeIP(site.Options.AnonymizeIP, site.Options.AnonymizeSecret, ip)
+	}
+
+	// Keep all the headers, for any tools that need them.
+	result.Headers = headers
+
 	//
 	// Now process known-good keys
 	//
 	// The comment-files I have are a little random/ad-hoc,
-	// so we ignore the keys from previous-systems.
+	// so other keys are only available via the Headers map.
 	//
 	for key, val := range headers {
 
@@ -70,47 +191,53 @@ func NewBlogComment(path string) (BlogComment, error) {
 		case "name":
 			result.Author = val
 		case "mail":
-			m := strings.ToLower(val)
-			h := fmt.Sprintf("%x", md5.Sum([]byte(m)))
-			result.Icon = "//www.gravatar.com/avatar/" + h + ";size=32"
+			result.Email = val
+			result.Icon, result.AvatarID = avatarIcon(site, val)
+		case "ip-address":
+			result.IP = val
+		case "user-agent":
+			result.UserAgent = val
 
 		case "link":
-			result.Link = val
-			if !strings.HasPrefix(result.Link, "http") {
-				result.Link = "http://" + result.Link
-			}
+			result.Link = commentLink(val)
+		case "type":
+			result.Type = val
 
 		}
 	}
 
 	//
-	// Our comment files will be:
-	//
-	//   $title.html.$ctime
-	//
-	// So the suffix here will be ".$ctime", extract that
-	// to just the creation time (seconds past the unix epoch).
-	//
-	suffix := filepath.Ext(path)
-	suffix = strings.TrimPrefix(suffix, ".")
-
-	//
-	// Convert the seconds-past-epoch to a number
+	// The date comes from the suffix of the filename.
 	//
-	i, err := strconv.ParseInt(suffix, 10, 64)
+	result.Date, err = commentDate(path)
 	if err != nil {
 		return result, err
 	}
 
 	//
-	// Now convert the unix-time to a real time.
-	//
-	result.Date = time.Unix(i, 0)
-
-	//
-	// Save the body and return the object.
+	// Render & sanitize the body, then return the object.
 	//
-	result.Body = body
+	result.Body = renderCommentBody(body)
 
 	return result, nil
 }
+
+// commentLink returns the user-submitted URL of a comment, if it is an
+// absolute http, or https, URL.  A URL without a scheme is assumed to
+// be http.
+func commentLink(link string) string {
+
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return ""
+	}
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
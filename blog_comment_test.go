@@ -29,6 +29,29 @@ func TestValid(t *testing.T) {
 	if b.Link != "http://example.net" {
 		t.Errorf("link doesn't contain a http-prefix: %s", b.Link)
 	}
+
+	if b.Email != "steve@example.com" || b.IP != "1.2.3.4" || !strings.HasPrefix(b.UserAgent, "Mozilla/5.0") {
+		t.Errorf("unexpected submitter details %s %s %s", b.Email, b.IP, b.UserAgent)
+	}
+
+	if b.Headers["user-agent"] != b.UserAgent || len(b.Headers) != 5 {
+		t.Errorf("unexpected headers %v", b.Headers)
+	}
+}
+
+// Test IP addresses are anonymised when comments are loaded.
+func TestAnonymizedComment(t *testing.T) {
+
+	site := &Ephemeris{Options: Options{AnonymizeIP: AnonymizeTruncate}}
+
+	b, err := loadBlogComment("_test/blog_comment/valid.html.12345", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	if b.IP != "1.2.3.0" || b.Headers["ip-address"] != "1.2.3.0" {
+		t.Errorf("address wasn't anonymised %s %v", b.IP, b.Headers)
+	}
 }
 
 // Test reading a badly-named comment-file
@@ -60,3 +83,53 @@ func TestBadHeader(t *testing.T) {
 		t.Errorf("the error we got didn't seem to refer to parsing 'html' as an integer")
 	}
 }
+
+// Test that comment-bodies are rendered as markdown, and sanitized.
+func TestSanitizedBody(t *testing.T) {
+
+	b, err := NewBlogComment("_test/blog_comment/unsafe.html.12345")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	// The markdown link should be converted, and marked.
+	if !strings.Contains(b.Body, `<a href="https://example.org/" rel="nofollow ugc">link</a>`) {
+		t.Errorf("comment-body didn't contain our rendered link: %s", b.Body)
+	}
+
+	// None of the dangerous content should survive.
+	for _, bad := range []string{"<script", "alert", "javascript:", "onclick"} {
+		if strings.Contains(b.Body, bad) {
+			t.Errorf("comment-body contained '%s': %s", bad, b.Body)
+		}
+	}
+}
+
+// Test that only http, and https, links are accepted.
+func TestCommentLink(t *testing.T) {
+
+	tests := map[string]string{
+		"example.net":                    "http://example.net",
+		"https://example.net/a?b=c":      "https://example.net/a?b=c",
+		` http://example.net/"onclick=x`: "http://example.net/%22onclick=x",
+		"javascript:alert(1)":            "",
+		"ftp://example.net/":             "",
+		"http://":                        "",
+		"":                               "",
+	}
+
+	for in, out := range tests {
+		if got := commentLink(in); got != out {
+			t.Errorf("link %q became %q, not %q", in, got, out)
+		}
+	}
+}
+
+// Test that links can't choose their own rel-attribute.
+func TestCommentRel(t *testing.T) {
+
+	body := renderCommentBody(`<a href="https://example.org/" rel="me">me</a>`)
+	if strings.TrimSpace(body) != `<p><a href="https://example.org/" rel="nofollow ugc">me</a></p>` {
+		t.Errorf("unexpected body %s", body)
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/shurcooL/github_flavored_markdown"
-	"github.com/skx/headerfile"
 )
 
+// linkRegexp matches the characters which are replaced when creating
+// the link to an entry from its title, we only want letters and numbers.
+var linkRegexp = regexp.MustCompile("[^a-zA-Z0-9]")
+
 // BlogEntry holds a single blog-post.
 //
 // A post has a series of attributes associated with it, as you would
@@ -35,8 +37,57 @@ type BlogEntry struct {
 	// Date is when the post was created.
 	Date time.Time
 
+	// Image holds the absolute link to an image representing the
+	// post, if it has an "Image:" header.
+	Image string
+
+	// Series holds the name of the series this entry is part of,
+	// if any.
+	Series string
+
+	// Part holds the position of this entry within its series, or
+	// zero if the part isn't numbered.
+	Part int
+
 	// CommentData contains any comments left upon this entry.
 	CommentData []BlogComment
+
+	// CommentState holds the value of the "Comments:" header, if
+	// present, which will be either "open" or "closed".
+	CommentState string
+}
+
+// The values which may be used in the "Comments:" header.
+const (
+	// CommentStateOpen means an entry always accepts comments.
+	CommentStateOpen = "open"
+
+	// CommentStateClosed means an entry never accepts comments.
+	CommentStateClosed = "closed"
+)
+
+// CommentsOpen returns true if the given entry accepts comments at the
+// given time, where newest is the most recent entry of the site.
+//
+// An explicit "Comments:" header always wins, otherwise comments are
+// accepted for `days` days after the entry was published.  If there is
+// no comment-period, days is zero, only the newest entry accepts them.
+func CommentsOpen(entry BlogEntry, newest BlogEntry, days int, now time.Time) bool {
+
+	switch entry.CommentState {
+	case CommentStateOpen:
+		return true
+	case CommentStateClosed:
+		return false
+	}
+
+	if days == 0 {
+		return entry.Path == newest.Path
+	}
+	if days < 0 {
+		return false
+	}
+	return now.Before(entry.Date.AddDate(0, 0, days))
 }
 
 // Year returns the year of a blog-post, as a string.
@@ -60,6 +111,11 @@ func (b BlogEntry) MonthNumber() string {
 	return (fmt.Sprintf("%02d", int(b.Date.Month())))
 }
 
+// CommentCount returns the number of comments left upon the entry.
+func (b BlogEntry) CommentCount() int {
+	return len(b.CommentData)
+}
+
 // NewBlogEntry creates a new blog object from the contents of the given
 // file.
 //
@@ -70,21 +126,13 @@ func NewBlogEntry(path string, site *Ephemeris) (BlogEntry, error) {
 	// The structure we'll return
 	var result BlogEntry
 
-	// Create a helper to read the entry.
-	reader := headerfile.New(path)
-
-	// Read the headers from the post
-	headers, err := reader.Headers()
+	// Read the headers, and the body, from the post, which may
+	// be cached.
+	headers, body, err := site.cache.parse(path)
 	if err != nil {
 		return result, err
 	}
 
-	// Read the body from the post
-	//
-	// errors can't happen here, because if they were present
-	// they would have happened in the header-read.
-	body, _ := reader.Body()
-
 	// Sanity-check the headers
 	for key, val := range headers {
 
@@ -100,9 +148,8 @@ func NewBlogEntry(path string, site *Ephemeris) (BlogEntry, error) {
 		case "title", "subject":
 			result.Title = val
 		case "format":
-			if val == "markdown" {
-				body = string(github_flavored_markdown.Markdown([]byte(body)))
-			} else {
+			// Markdown has already been converted to HTML.
+			if val != "markdown" {
 				return result, fmt.Errorf("unknown entry-format %s", val)
 			}
 		case "tags":
@@ -115,6 +162,22 @@ func NewBlogEntry(path string, site *Ephemeris) (BlogEntry, error) {
 				}
 			}
 			sort.Strings(result.Tags)
+		case "image":
+			result.Image = absoluteLink(site.Prefix, strings.TrimSpace(val))
+		case "series":
+			result.Series = strings.TrimSpace(val)
+		case "part":
+			part, err := strconv.Atoi(strings.TrimSpace(val))
+			if err != nil || part < 1 {
+				return result, fmt.Errorf("invalid part %s", val)
+			}
+			result.Part = part
+		case "comments":
+			state := strings.ToLower(strings.TrimSpace(val))
+			if state != CommentStateOpen && state != CommentStateClosed {
+				return result, fmt.Errorf("unknown comments-state %s", val)
+			}
+			result.CommentState = state
 		default:
 			return result, fmt.Errorf("unknown header-key %s in file %s", key, path)
 		}
@@ -126,15 +189,10 @@ func NewBlogEntry(path string, site *Ephemeris) (BlogEntry, error) {
 	result.Path = path
 	result.Content = body
 
-	//
-	// Make a Regex to say we only want letters and numbers
-	//
-	reg := regexp.MustCompile("[^a-zA-Z0-9]")
-
 	//
 	// Normalise the output
 	//
-	link := reg.ReplaceAllString(result.Title, "_") + ".html"
+	link := linkRegexp.ReplaceAllString(result.Title, "_") + ".html"
 
 	//
 	// Make our link absolute.
@@ -156,7 +214,7 @@ func NewBlogEntry(path string, site *Ephemeris) (BlogEntry, error) {
 			//
 			// Read the blog-comment
 			//
-			x, err := NewBlogComment(comment)
+			x, err := loadBlogComment(comment, site)
 			if err != nil {
 				return result, err
 			}
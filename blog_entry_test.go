@@ -3,6 +3,7 @@ package ephemeris
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 // Test reading a file that doesn't exist.
@@ -114,3 +115,107 @@ func TestBlogFormat(t *testing.T) {
 		t.Errorf("the blog body doesn't have our link in it: %s", b.Content)
 	}
 }
+
+// Test the `comments` header, and the comment-period.
+func TestCommentsOpen(t *testing.T) {
+
+	// fake-site, with a ten-day comment period.
+	site, err := NewWithOptions("", "", "", Options{CommentDays: 10})
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	// The date of all our test-entries.
+	date := time.Date(2005, 1, 13, 21, 3, 0, 0, time.UTC)
+	later := date.AddDate(1, 0, 0)
+
+	// An open post always accepts comments.
+	b, err := NewBlogEntry("_test/blog_entry/comments-open.txt", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if !CommentsOpen(b, BlogEntry{}, 10, later) {
+		t.Errorf("expected comments to be open")
+	}
+
+	// A closed post never does.
+	closed, err := NewBlogEntry("_test/blog_entry/comments-closed.txt", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if CommentsOpen(closed, closed, 10, date) || CommentsOpen(closed, closed, 0, date) {
+		t.Errorf("expected comments to be closed")
+	}
+
+	// Otherwise we use the comment-period.
+	b, err = NewBlogEntry("_test/blog_entry/1.txt", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if !CommentsOpen(b, b, 10, date.AddDate(0, 0, 9)) {
+		t.Errorf("expected comments to be open within the comment-period")
+	}
+	if CommentsOpen(b, b, 10, date.AddDate(0, 0, 10)) {
+		t.Errorf("expected comments to be closed after the comment-period")
+	}
+
+	// Without a comment-period only the newest entry is open.
+	if !CommentsOpen(b, b, 0, later) {
+		t.Errorf("expected comments to be open upon the newest entry")
+	}
+	if CommentsOpen(b, closed, 0, date) {
+		t.Errorf("expected comments to be closed upon older entries")
+	}
+
+	// The site finds the newest entry.
+	old := b
+	old.Path = "old.txt"
+	old.Date = date.AddDate(-1, 0, 0)
+	site.BlogEntries = []BlogEntry{b, old}
+	if newest := site.Newest(); newest.Path != b.Path || CommentsOpen(old, newest, 0, date) {
+		t.Errorf("unexpected newest entry %s", newest.Path)
+	}
+
+	// Entries published together are ordered by path, so the
+	// newest entry is the most recent, whatever their order.
+	tied := b
+	tied.Path = "a.txt"
+	for _, entries := range [][]BlogEntry{{b, tied}, {tied, b}} {
+		site.BlogEntries = entries
+		if newest, recent := site.Newest(), site.Recent(1); newest.Path != recent[0].Path {
+			t.Errorf("newest entry %s differs from the most recent %s", newest.Path, recent[0].Path)
+		}
+	}
+
+	// Bogus values are errors
+	_, err = NewBlogEntry("_test/blog_entry/comments-bogus.txt", site)
+	if err == nil {
+		t.Errorf("we expected an error, but found none")
+	}
+	if !strings.Contains(err.Error(), "unknown comments-state") {
+		t.Errorf("the error didn't look like a comments-state failure: %s", err.Error())
+	}
+}
+
+// Test reading the series of a blog-entry.
+func TestBlogSeries(t *testing.T) {
+
+	// fake-site
+	site, err := New("", "", "")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	b, err := NewBlogEntry("_test/blog_entry/series.txt", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if b.Series != "Writing a Compiler" || b.Part != 2 {
+		t.Errorf("unexpected series %s part %d", b.Series, b.Part)
+	}
+
+	_, err = NewBlogEntry("_test/blog_entry/series-bogus-part.txt", site)
+	if err == nil || !strings.Contains(err.Error(), "invalid part") {
+		t.Errorf("expected an error with a bogus part, got %v", err)
+	}
+}
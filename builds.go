@@ -0,0 +1,350 @@
+package ephemeris
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// buildFormat is the format of the names of build-directories, which
+// sort in the order the builds were made.
+const buildFormat = "20060102T150405.000000000"
+
+// Builds manages atomic builds of a site.
+//
+// Each build is generated within its own directory, beside the output,
+// and the output is a symlink to the build which is live.  Once a build
+// is complete the symlink is replaced, atomically, so visitors never see
+// a partial build, and a failed build never replaces a working one.
+//
+// The previous builds are kept, so that the site may be rolled back.
+type Builds struct {
+	// Output is the path to the output, which is a symlink to the
+	// live build.
+	Output string
+
+	// Keep is the number of previous builds to keep, in addition to
+	// the live one.
+	Keep int
+}
+
+// Dir returns the directory which holds the builds, which is a sibling
+// of the output with ".builds" appended to its name.
+func (b *Builds) Dir() string {
+	return filepath.Clean(b.Output) + ".builds"
+}
+
+// List returns the paths of the builds, oldest first.
+func (b *Builds) List() ([]string, error) {
+
+	entries, err := os.ReadDir(b.Dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var builds []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			builds = append(builds, filepath.Join(b.Dir(), e.Name()))
+		}
+	}
+	sort.Strings(builds)
+	return builds, nil
+}
+
+// Live returns the path of the live build, or the empty string if the
+// output isn't a symlink to one of our builds.
+func (b *Builds) Live() (string, error) {
+
+	target, err := os.Readlink(filepath.Clean(b.Output))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		// Not a symlink.
+		if info, serr := os.Lstat(b.Output); serr == nil && info.Mode()&fs.ModeSymlink == 0 {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(filepath.Clean(b.Output)), target)
+	}
+	if filepath.Dir(target) != b.Dir() {
+		return "", nil
+	}
+	return target, nil
+}
+
+// Stage creates, and returns the path of, a new directory for a build.
+//
+// The files within the current output which are hidden, or which match
+// the ignore patterns, are copied into the new build, since they're not
+// generated.  See Manifest.Stale for the syntax of the patterns.
+func (b *Builds) Stage(ignore []string) (string, error) {
+
+	err := os.MkdirAll(b.Dir(), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(b.Dir(), time.Now().UTC().Format(buildFormat))
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	// The output is usually a symlink to the live build.
+	if current, err := filepath.EvalSymlinks(b.Output); err == nil {
+		err = copyPreserved(current, dir, ignore)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// copyPreserved copies the files beneath src which are hidden, or match
+// the ignore patterns, to the same location beneath dst.
+func copyPreserved(src string, dst string, ignore []string) error {
+
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !strings.HasPrefix(d.Name(), ".") && !ignored(rel, ignore) {
+			return nil
+		}
+
+		// Copy everything beneath a preserved directory.
+		err = filepath.WalkDir(file, func(f string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			r, err := filepath.Rel(src, f)
+			if err != nil {
+				return err
+			}
+			dest := filepath.Join(dst, r)
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case d.IsDir():
+				return os.MkdirAll(dest, 0755)
+			case info.Mode()&fs.ModeSymlink != 0:
+				link, err := os.Readlink(f)
+				if err != nil {
+					return err
+				}
+				return os.Symlink(link, dest)
+			default:
+				err = os.MkdirAll(filepath.Dir(dest), 0755)
+				if err != nil {
+					return err
+				}
+				return copyFile(f, dest, info)
+			}
+		})
+		if err == nil && d.IsDir() {
+			return filepath.SkipDir
+		}
+		return err
+	})
+}
+
+// copyFile copies a single file, preserving its mode and times.
+func copyFile(src string, dst string, info fs.FileInfo) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// Publish makes the given build live, by replacing the output with a
+// symlink to it, and then removes all but the most recent previous
+// builds.
+//
+// If the output is a directory, rather than a symlink, it is moved into
+// the builds-directory so it may be rolled back to.  That move, unlike
+// later ones, is not atomic.
+func (b *Builds) Publish(dir string) error {
+
+	previous, err := b.Live()
+	if err != nil {
+		return err
+	}
+
+	err = b.point(dir)
+	if err != nil {
+		return err
+	}
+	return b.prune(previous, dir)
+}
+
+// Discard removes a build which failed, and so was never published.
+func (b *Builds) Discard(dir string) error {
+
+	live, err := b.Live()
+	if err != nil {
+		return err
+	}
+	if dir == live || filepath.Dir(dir) != b.Dir() {
+		return fmt.Errorf("%s is not an unpublished build", dir)
+	}
+	return os.RemoveAll(dir)
+}
+
+// Rollback makes the build before the live one live again, returning
+// its path.
+func (b *Builds) Rollback() (string, error) {
+
+	live, err := b.Live()
+	if err != nil {
+		return "", err
+	}
+	if live == "" {
+		return "", fmt.Errorf("%s is not a symlink to a build", b.Output)
+	}
+
+	builds, err := b.List()
+	if err != nil {
+		return "", err
+	}
+
+	previous := ""
+	for _, build := range builds {
+		if build < live {
+			previous = build
+		}
+	}
+	if previous == "" {
+		return "", fmt.Errorf("there is no build before %s", filepath.Base(live))
+	}
+
+	return previous, b.point(previous)
+}
+
+// point replaces the output with a symlink to the given build.
+func (b *Builds) point(dir string) error {
+
+	output := filepath.Clean(b.Output)
+
+	// Migrate an existing output-directory.
+	info, err := os.Lstat(output)
+	if err == nil && info.IsDir() {
+		err = os.Rename(output, filepath.Join(b.Dir(), info.ModTime().UTC().Format(buildFormat)))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The link is relative, so the output may be moved.
+	from, err := filepath.Abs(filepath.Dir(output))
+	if err != nil {
+		return err
+	}
+	to, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Rel(from, to)
+	if err != nil {
+		return err
+	}
+
+	// Create the new link beside the output, then rename it into
+	// place, which replaces the old link atomically.
+	tmp := output + ".tmp"
+	os.Remove(tmp)
+	err = os.Symlink(target, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, output)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// prune removes the builds other than the live one, and the most recent
+// builds before it.
+//
+// Builds made after the previously live one, other than the live one,
+// are the remains of failed builds, or builds which were rolled back,
+// so they're always removed.
+func (b *Builds) prune(previous string, live string) error {
+
+	builds, err := b.List()
+	if err != nil {
+		return err
+	}
+
+	var older []string
+	for _, build := range builds {
+		switch {
+		case build == live:
+		case previous != "" && build > previous:
+			err = os.RemoveAll(build)
+			if err != nil {
+				return err
+			}
+		default:
+			older = append(older, build)
+		}
+	}
+
+	keep := b.Keep
+	if keep < 0 {
+		keep = 0
+	}
+	for len(older) > keep {
+		err = os.RemoveAll(older[0])
+		if err != nil {
+			return err
+		}
+		older = older[1:]
+	}
+	return nil
+}
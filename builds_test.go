@@ -0,0 +1,138 @@
+package ephemeris
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test staging, publishing, and rolling back builds.
+func TestBuilds(t *testing.T) {
+
+	root := t.TempDir()
+	output := filepath.Join(root, "output")
+
+	// An existing output-directory, with a hand-managed file.
+	os.MkdirAll(filepath.Join(output, "downloads"), 0755)
+	os.WriteFile(filepath.Join(output, "index.html"), []byte("original"), 0644)
+	os.WriteFile(filepath.Join(output, "downloads", "file.tar.gz"), []byte("download"), 0644)
+	os.WriteFile(filepath.Join(output, ".htaccess"), []byte("hidden"), 0644)
+
+	b := &Builds{Output: output, Keep: 1}
+
+	// read returns the contents of a file within the live output.
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(output, name))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	// build stages, and publishes, a build with the given index.
+	build := func(index string) string {
+		dir, err := b.Stage([]string{"downloads"})
+		if err != nil {
+			t.Fatalf("failed to stage: %s", err.Error())
+		}
+
+		// The hand-managed files were copied, the generated
+		// ones were not.
+		if _, err := os.Stat(filepath.Join(dir, "downloads", "file.tar.gz")); err != nil {
+			t.Errorf("ignored file wasn't copied into the build")
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".htaccess")); err != nil {
+			t.Errorf("hidden file wasn't copied into the build")
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+			t.Errorf("generated file was copied into the build")
+		}
+
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte(index), 0644)
+
+		// The build isn't live until it is published.
+		if read("index.html") == index {
+			t.Errorf("build was live before it was published")
+		}
+
+		err = b.Publish(dir)
+		if err != nil {
+			t.Fatalf("failed to publish: %s", err.Error())
+		}
+		if read("index.html") != index {
+			t.Errorf("build wasn't live after it was published")
+		}
+		return dir
+	}
+
+	first := build("first")
+
+	// The original directory was kept.
+	builds, _ := b.List()
+	if len(builds) != 2 {
+		t.Fatalf("expected two builds, got %v", builds)
+	}
+
+	second := build("second")
+
+	// Only one previous build is kept.
+	builds, _ = b.List()
+	if len(builds) != 2 || builds[0] != first || builds[1] != second {
+		t.Fatalf("unexpected builds %v", builds)
+	}
+	if live, _ := b.Live(); live != second {
+		t.Errorf("unexpected live build %s", live)
+	}
+
+	// The link is relative.
+	if target, _ := os.Readlink(output); filepath.IsAbs(target) {
+		t.Errorf("link %s is absolute", target)
+	}
+
+	// Roll back.
+	previous, err := b.Rollback()
+	if err != nil || previous != first || read("index.html") != "first" {
+		t.Fatalf("rollback failed %s %v %s", previous, err, read("index.html"))
+	}
+	if _, err := b.Rollback(); err == nil {
+		t.Errorf("expected an error rolling back past the oldest build")
+	}
+
+	// Discarding a build removes it, but the live build can't be
+	// discarded.
+	discarded, _ := b.Stage(nil)
+	if err := b.Discard(discarded); err != nil {
+		t.Errorf("failed to discard %s: %s", discarded, err.Error())
+	}
+	if _, err := os.Stat(discarded); !os.IsNotExist(err) {
+		t.Errorf("discarded build still exists")
+	}
+	if err := b.Discard(first); err == nil {
+		t.Errorf("expected an error discarding the live build")
+	}
+
+	// A failed build, which is never published, is removed by the
+	// next build, along with the build which was rolled back.
+	failed, _ := b.Stage(nil)
+	third := build("third")
+
+	builds, _ = b.List()
+	if len(builds) != 2 || builds[0] != first || builds[1] != third {
+		t.Fatalf("unexpected builds %v, having failed %s", builds, failed)
+	}
+}
+
+// Test rolling back an output which isn't a build.
+func TestBuildsRollbackDirectory(t *testing.T) {
+
+	output := filepath.Join(t.TempDir(), "output")
+	os.MkdirAll(output, 0755)
+
+	b := &Builds{Output: output}
+	if live, err := b.Live(); live != "" || err != nil {
+		t.Errorf("unexpected live build %s %v", live, err)
+	}
+	if _, err := b.Rollback(); err == nil {
+		t.Errorf("expected an error rolling back a directory")
+	}
+}
@@ -42,6 +42,8 @@ $link = lc($link)          if ($link);
 
 my $cap = $cgi->param('robot') || undef;
 
+my $notify = $cgi->param('notify') || undef;
+
 
 #
 # Strip newlines
@@ -119,6 +121,7 @@ print FILE "Mail: $mail\n";
 print FILE "Link: $link\n" if ( defined($link) );
 print FILE "User-Agent: $ENV{'HTTP_USER_AGENT'}\n";
 print FILE "IP-Address: $ENV{'REMOTE_ADDR'}\n";
+print FILE "Notify: yes\n" if ( defined($notify) && length($notify) );
 print FILE "\n";
 
 #
@@ -0,0 +1,203 @@
+package ephemeris
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ManifestFile is the name of the file, within the output-directory,
+// which records the files generated by the most recent build.  It is
+// hidden, so it is never removed, or published, itself.
+const ManifestFile = ".ephemeris-manifest"
+
+// Manifest records the files generated by a build, so that files which
+// an earlier build generated, but which are no longer generated, can be
+// found and removed.
+//
+// Files are recorded by their path relative to the output-directory,
+// using forward slashes.  A Manifest may be used from several goroutines
+// at once.
+type Manifest struct {
+	mu    sync.Mutex
+	files map[string]bool
+}
+
+// LoadManifest loads the manifest saved within the given output-directory
+// by Save.  If there is none an empty manifest is returned.
+func LoadManifest(dir string) (*Manifest, error) {
+
+	m := &Manifest{}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(string(data), "\n") {
+		if name != "" {
+			m.Add(name)
+		}
+	}
+	return m, nil
+}
+
+// Save writes the manifest within the given output-directory, so that
+// the next build may find the files which are no longer generated.
+func (m *Manifest) Save(dir string) error {
+
+	data := strings.Join(m.Files(), "\n") + "\n"
+
+	// Write to a temporary file, so that a failure doesn't leave a
+	// partial manifest behind.
+	file := filepath.Join(dir, ManifestFile)
+	err := os.WriteFile(file+".tmp", []byte(data), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// Add records that the named file was generated.
+func (m *Manifest) Add(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.files == nil {
+		m.files = make(map[string]bool)
+	}
+	m.files[path.Clean(filepath.ToSlash(name))] = true
+}
+
+// Contains returns true if the named file was generated.
+func (m *Manifest) Contains(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.files[path.Clean(filepath.ToSlash(name))]
+}
+
+// Files returns the names of the generated files, in sorted order.
+func (m *Manifest) Files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stale returns the files which the previous build generated, but this
+// one didn't, which are still present beneath the given output-directory,
+// in sorted order.
+//
+// Only files recorded in the previous manifest are ever returned, so
+// files which were placed within the output-directory by hand are never
+// considered stale.  Files beneath directories which match any of the
+// ignore patterns are never returned either.  Patterns use the syntax
+// of path.Match, and are matched against the path relative to the
+// output-directory, so "downloads" ignores that directory and everything
+// beneath it.  Hidden files and directories, whose names begin with ".",
+// are always ignored.
+func (m *Manifest) Stale(dir string, previous *Manifest, ignore []string) ([]string, error) {
+
+	var stale []string
+
+	for _, name := range previous.Files() {
+
+		// Names which aren't relative, and which might escape the
+		// output-directory, are never removed.
+		if !fs.ValidPath(name) || name == "." || m.Contains(name) || kept(name, ignore) {
+			continue
+		}
+
+		_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, name)
+	}
+
+	return stale, nil
+}
+
+// kept returns true if the given path, or any directory containing it,
+// is hidden, or matches any of the patterns.
+func kept(name string, patterns []string) bool {
+	for p := name; p != "."; p = path.Dir(p) {
+		if strings.HasPrefix(path.Base(p), ".") || ignored(p, patterns) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored returns true if the given path matches any of the patterns.
+func ignored(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveStale removes the given files, which are relative to the output
+// directory, along with any directories which are left empty.
+func RemoveStale(dir string, stale []string) error {
+
+	parents := make(map[string]bool)
+
+	for _, name := range stale {
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for p := path.Dir(name); p != "."; p = path.Dir(p) {
+			parents[p] = true
+		}
+	}
+
+	// Remove the deepest directories first, so that their parents
+	// may then be empty too.
+	var dirs []string
+	for p := range parents {
+		dirs = append(dirs, p)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if len(dirs[i]) == len(dirs[j]) {
+			return dirs[i] < dirs[j]
+		}
+		return len(dirs[i]) > len(dirs[j])
+	})
+
+	for _, p := range dirs {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+
+		entries, err := os.ReadDir(full)
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		err = os.Remove(full)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
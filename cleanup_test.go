@@ -0,0 +1,145 @@
+package ephemeris
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// Test recording generated files.
+func TestManifest(t *testing.T) {
+
+	var m Manifest
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"tags/go/index.html", "index.html", "./about/index.html"} {
+		wg.Add(1)
+		go func(name string) {
+			m.Add(name)
+			wg.Done()
+		}(name)
+	}
+	wg.Wait()
+
+	expected := []string{"about/index.html", "index.html", "tags/go/index.html"}
+	if !reflect.DeepEqual(m.Files(), expected) {
+		t.Errorf("unexpected files %v", m.Files())
+	}
+	if !m.Contains(filepath.Join("tags", "go", "index.html")) || m.Contains("tags/index.html") {
+		t.Errorf("unexpected result from Contains")
+	}
+}
+
+// Test saving, and loading, a manifest.
+func TestManifestSave(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// There is no manifest at first.
+	m, err := LoadManifest(dir)
+	if err != nil || len(m.Files()) != 0 {
+		t.Fatalf("unexpected manifest %v %v", m, err)
+	}
+
+	m.Add("index.html")
+	m.Add("tags/go/index.html")
+	err = m.Save(dir)
+	if err != nil {
+		t.Fatalf("failed to save: %s", err.Error())
+	}
+
+	loaded, err := LoadManifest(dir)
+	if err != nil || !reflect.DeepEqual(loaded.Files(), m.Files()) {
+		t.Errorf("unexpected manifest %v %v", loaded.Files(), err)
+	}
+}
+
+// Test finding, and removing, stale files.
+func TestStale(t *testing.T) {
+
+	dir := t.TempDir()
+
+	for _, name := range []string{
+		"index.html",
+		"old_post.html",
+		"tags/go/index.html",
+		"tags/removed/index.html",
+		"archive/2019/01/index.html",
+		"downloads/file.tar.gz",
+		"favicon.ico",
+		"keep.txt",
+		".htaccess",
+		".well-known/security.txt",
+	} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		os.WriteFile(file, []byte(name), 0644)
+	}
+	os.Symlink("index.html", filepath.Join(dir, "Old_Post.html"))
+
+	// The previous build generated these files, some of which are
+	// hand-managed now, or have already been removed.
+	var previous Manifest
+	for _, name := range []string{
+		"index.html",
+		"old_post.html",
+		"Old_Post.html",
+		"tags/go/index.html",
+		"tags/removed/index.html",
+		"archive/2019/01/index.html",
+		"downloads/file.tar.gz",
+		"keep.txt",
+		".htaccess",
+		"missing.html",
+		"../escape.html",
+	} {
+		previous.Add(name)
+	}
+
+	var m Manifest
+	m.Add("index.html")
+	m.Add("tags/go/index.html")
+
+	stale, err := m.Stale(dir, &previous, []string{"downloads", "/keep.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	expected := []string{
+		"Old_Post.html",
+		"archive/2019/01/index.html",
+		"old_post.html",
+		"tags/removed/index.html",
+	}
+	if !reflect.DeepEqual(stale, expected) {
+		t.Fatalf("unexpected stale files %v", stale)
+	}
+
+	err = RemoveStale(dir, stale)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	// The stale files, and empty directories, are gone.
+	for _, name := range append(stale, "archive", "tags/removed") {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed", name)
+		}
+	}
+
+	// Everything else remains, including files which no build
+	// generated.
+	for _, name := range []string{"index.html", "tags/go/index.html", "downloads/file.tar.gz", "favicon.ico", "keep.txt", ".htaccess", ".well-known/security.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed", name)
+		}
+	}
+
+	// Nothing is stale now.
+	stale, err = m.Stale(dir, &previous, []string{"downloads", "keep.txt"})
+	if err != nil || len(stale) != 0 {
+		t.Errorf("unexpected stale files %v %v", stale, err)
+	}
+}
@@ -0,0 +1,47 @@
+// builds.go - Sub-commands for working with atomic builds.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+)
+
+// rollbackCommand makes the build before the live one live again.
+func rollbackCommand(args []string) error {
+
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	list := fs.Bool("list", false, "List the builds, rather than rolling back.")
+	fs.Parse(args)
+
+	b := builds()
+
+	if *list {
+		all, err := b.List()
+		if err != nil {
+			return err
+		}
+		live, err := b.Live()
+		if err != nil {
+			return err
+		}
+
+		for _, build := range all {
+			marker := " "
+			if build == live {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, filepath.Base(build))
+		}
+		return nil
+	}
+
+	previous, err := b.Rollback()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s now points to %s\n", config.OutputPath, previous)
+	return nil
+}
@@ -0,0 +1,184 @@
+// comments.go - Sub-commands for working with comments.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/skx/ephemeris"
+)
+
+// runCommand runs the sub-command named by the first of the given
+// (non-flag) arguments.
+func runCommand(args []string) error {
+
+	switch args[0] {
+	case "comments":
+		return commentsCommand(args[1:])
+	case "rollback":
+		return rollbackCommand(args[1:])
+	}
+
+	return fmt.Errorf("unknown command %s", args[0])
+}
+
+// commentsCommand runs one of the "comments" sub-commands.
+func commentsCommand(args []string) error {
+
+	if len(args) < 1 {
+		return fmt.Errorf("usage: ephemeris comments export|import|erase [options]")
+	}
+
+	switch args[0] {
+	case "export":
+		return commentsExport(args[1:])
+	case "import":
+		return commentsImport(args[1:])
+	case "erase":
+		return commentsErase(args[1:])
+	}
+
+	return fmt.Errorf("unknown comments command %s", args[0])
+}
+
+// commentsExport writes all comments, in the specified format, to
+// either a file or STDOUT.
+func commentsExport(args []string) error {
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", ephemeris.FormatJSON, "The format to export, one of 'json', 'wxr', or 'disqus'.")
+	output := fs.String("output", "", "The file to write to, instead of STDOUT.")
+	fs.Parse(args)
+
+	site, err := newSite()
+	if err != nil {
+		return err
+	}
+
+	export, err := site.ExportComments()
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return ephemeris.WriteCommentExport(out, export, *format)
+}
+
+// commentsImport reads comments, in the specified format, from the named
+// file and writes them into the comment-directory.
+func commentsImport(args []string) error {
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", ephemeris.FormatJSON, "The format to import, one of 'json', 'wxr', or 'disqus'.")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ephemeris comments import [-format=json|wxr|disqus] file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	export, err := ephemeris.ReadCommentExport(f, *format)
+	if err != nil {
+		return err
+	}
+
+	site, err := newSite()
+	if err != nil {
+		return err
+	}
+
+	result, err := site.ImportComments(export, config.CommentsPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d comments, skipped %d already present.\n", result.Imported, result.Duplicates)
+	for _, link := range result.Unmatched {
+		fmt.Printf("No entry found for %s\n", link)
+	}
+	return nil
+}
+
+// commentsErase removes, or anonymises, every comment submitted with the
+// given email-address or name.
+//
+// By default the matching comments are only listed, they're changed when
+// `-yes` is given.
+func commentsErase(args []string) error {
+
+	fs := flag.NewFlagSet("erase", flag.ExitOnError)
+	email := fs.String("email", "", "Erase comments submitted with this email-address.")
+	name := fs.String("name", "", "Erase comments submitted with this name.")
+	anonymize := fs.Bool("anonymize", false, "Anonymise the comments, rather than deleting them.")
+	yes := fs.Bool("yes", false, "Make the changes, rather than listing the comments which match.")
+	fs.Parse(args)
+
+	if *email == "" && *name == "" {
+		return fmt.Errorf("usage: ephemeris comments erase [-email=addr] [-name=name] [-anonymize] [-yes]")
+	}
+
+	//
+	// Comments awaiting moderation are included too.
+	//
+	dirs := []string{config.CommentsPath}
+	if _, err := os.Stat(config.PendingPath); err == nil && filepath.Clean(config.PendingPath) != filepath.Clean(config.CommentsPath) {
+		dirs = append(dirs, config.PendingPath)
+	}
+
+	var files []string
+	for _, dir := range dirs {
+		found, skipped, err := ephemeris.FindCommentsBy(dir, *email, *name)
+		if err != nil {
+			return err
+		}
+		for _, file := range skipped {
+			fmt.Fprintf(os.Stderr, "Skipping %s, which couldn't be read\n", file)
+		}
+		files = append(files, found...)
+	}
+
+	action, done := "delete", "Deleted"
+	if *anonymize {
+		action, done = "anonymise", "Anonymised"
+	}
+
+	if !*yes {
+		for _, file := range files {
+			fmt.Printf("Would %s %s\n", action, file)
+		}
+		fmt.Printf("%d comments match, run again with -yes to %s them.\n", len(files), action)
+		return nil
+	}
+
+	for _, file := range files {
+		var err error
+		if *anonymize {
+			err = ephemeris.AnonymizeComment(file)
+		} else {
+			err = os.Remove(file)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s\n", done, file)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 )
 
@@ -28,6 +29,50 @@ type Config struct {
 	// comments to, if that support is enabled.
 	CommentAPI string
 
+	// WebmentionAPI holds the endpoint which receives webmentions,
+	// if that support is enabled.
+	WebmentionAPI string
+
+	// WebmentionState is the path to the file which records the
+	// webmentions we've sent.
+	WebmentionState string
+
+	// SMTPHost is the mail-server used to send notifications of new
+	// comments, if this is empty no notifications are sent.
+	SMTPHost string
+
+	// SMTPPort is the port of the mail-server, defaulting to 25.
+	SMTPPort int
+
+	// SMTPUsername and SMTPPassword are used to authenticate to the
+	// mail-server, if required.
+	SMTPUsername string
+	SMTPPassword string
+
+	// NotifyFrom is the sender of notification emails.
+	NotifyFrom string
+
+	// NotifyOwner is the address which is sent a copy of each new
+	// comment.
+	NotifyOwner string
+
+	// ModerationSecret is used to sign the links which approve, or
+	// delete, comments.  If this is set comments are held in the
+	// pending-directory until they're approved.
+	ModerationSecret string
+
+	// ModerationURL is the public URL of the server's `/moderate`
+	// handler, which the moderation links point to.
+	ModerationURL string
+
+	// ModerationDays is the number of days for which moderation links
+	// remain valid, defaulting to seven.
+	ModerationDays int
+
+	// PendingPath is the directory holding comments which are awaiting
+	// moderation, defaulting to "pending/".
+	PendingPath string
+
 	// Comments points to a directory containing comment-files.
 	// Depreciated in favour of `CommentPath`
 	Comments string
@@ -35,15 +80,168 @@ type Config struct {
 	// The path to the directory containing comments.
 	CommentsPath string
 
+	// PagesPath is the path to the directory containing standalone
+	// pages, such as an "about" page, if any.
+	PagesPath string
+
+	// StaticPath is the path to a directory of static files, such as
+	// images and downloads, which are copied into the output.
+	StaticPath string
+
+	// ImageWidths holds the widths of the resized variants generated
+	// for each image within posts and pages.
+	//
+	// If this is empty images are not processed.
+	ImageWidths []int
+
+	// ImageQuality is the quality of resized JPEG images, defaulting
+	// to 85.
+	ImageQuality int
+
+	// ImageCachePath is the directory in which resized images are
+	// cached between builds, defaulting to "image-cache/".
+	ImageCachePath string
+
+	// PostCachePath is the directory in which parsed posts are cached,
+	// so that unchanged posts needn't be rendered again.
+	//
+	// If this is empty posts aren't cached.
+	PostCachePath string
+
 	// Output is the path to which we write our output files.
 	OutputPath string
 
+	// AtomicBuilds causes each build to be written to a new directory,
+	// beside the output, which replaces the output only once the build
+	// has succeeded.
+	AtomicBuilds bool
+
+	// KeepBuilds is the number of previous builds kept, for rolling
+	// back, when AtomicBuilds is set.
+	//
+	// If this is zero it defaults to three, set it to a negative
+	// number to keep none.
+	KeepBuilds int
+
+	// RemoveStale causes the files which an earlier build generated,
+	// but which the current build didn't, to be removed from the
+	// output-directory.
+	RemoveStale bool
+
+	// OutputIgnore holds patterns matching files, and directories,
+	// within the output-directory which are managed by hand, and
+	// so are never removed as stale.
+	OutputIgnore []string
+
 	// ThemePath contains the directory to look in for theme-files
 	ThemePath string
 
+	// Avatars controls the icons shown upon comments, this may be
+	// "none", "gravatar", or "identicon".
+	//
+	// If empty this defaults to "gravatar".
+	Avatars string
+
+	// AvatarSalt is used to salt the hash of each commenter's email
+	// address, and must be set when "identicon" avatars are used.
+	AvatarSalt string
+
+	// AnonymizeIP controls how commenters' IP addresses are
+	// anonymised when comments are loaded, this may be "none",
+	// "truncate", or "hash".
+	AnonymizeIP string
+
+	// AnonymizeSecret is the secret key used to hash commenters' IP
+	// addresses, which must be set when "hash" anonymisation is used.
+	AnonymizeSecret string
+
+	// CommentDays is the number of days after publication for which
+	// entries will show the 'add comment' form.
+	//
+	// If this is zero only the most recent entry will show the form,
+	// along with any entries which have a "Comments: open" header.
+	CommentDays int
+
+	// RecentComments is the number of comments shown upon the
+	// recent-comments page, and in the comments feed.
+	//
+	// If this is zero it defaults to ten, set it to a negative
+	// number to generate neither the page nor the feed.
+	RecentComments int
+
+	// RelatedPosts is the number of related posts shown upon each
+	// entry.
+	//
+	// If this is zero it defaults to five, set it to a negative
+	// number to show none.
+	RelatedPosts int
+
+	// Robots holds the lines of the generated robots.txt file, which
+	// are followed by a reference to the sitemap.
+	//
+	// If this is empty all robots are allowed to index everything.
+	Robots []string
+
 	// AddComments is used to determine whether there is an 'add comment'
-	// form shown on the most recent entry.
+	// form shown on any entry.
 	AddComments bool
+
+	// Jobs is the number of posts which are parsed, and pages which are
+	// rendered, concurrently, from the command-line.
+	Jobs int
+}
+
+// setDefaults populates any missing settings with their default values,
+// and migrates any legacy key-names.
+func (c *Config) setDefaults() {
+
+	if c.OutputPath == "" {
+		c.OutputPath = "output"
+	}
+	if c.PostsPath == "" {
+
+		// Migration of legacy key-name
+		if c.Posts != "" {
+			c.PostsPath = c.Posts
+		} else {
+			c.PostsPath = "data/"
+		}
+	}
+	if c.CommentsPath == "" {
+		// Migration of legacy key-name
+		if c.Comments != "" {
+			c.CommentsPath = c.Comments
+		} else {
+			c.CommentsPath = "comments/"
+		}
+	}
+
+	if c.RecentComments == 0 {
+		c.RecentComments = 10
+	}
+
+	if c.ImageCachePath == "" {
+		c.ImageCachePath = "image-cache/"
+	}
+
+	if c.KeepBuilds == 0 {
+		c.KeepBuilds = 3
+	}
+
+	if c.RelatedPosts == 0 {
+		c.RelatedPosts = 5
+	}
+
+	if c.WebmentionState == "" {
+		c.WebmentionState = "webmentions.json"
+	}
+
+	if c.ModerationDays == 0 {
+		c.ModerationDays = 7
+	}
+	if c.PendingPath == "" {
+		c.PendingPath = "pending/"
+	}
 }
 
 // loadConfig loads the specified JSON file, and returns a
@@ -78,7 +276,19 @@ func loadConfig(path string) (Config, error) {
 	}
 
 	//
-	// Return the populated structure.
+	// Return the populated structure, if it is valid.
 	//
-	return config, nil
+	return config, config.validate()
+}
+
+// validate returns an error if the configuration is inconsistent.
+func (c *Config) validate() error {
+
+	if c.Avatars == "identicon" && c.AvatarSalt == "" {
+		return fmt.Errorf("AvatarSalt must be set when Avatars is identicon")
+	}
+	if c.AnonymizeIP == "hash" && c.AnonymizeSecret == "" {
+		return fmt.Errorf("AnonymizeSecret must be set when AnonymizeIP is hash")
+	}
+	return nil
 }
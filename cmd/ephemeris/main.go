@@ -2,7 +2,8 @@
 // from a series of text-files.
 //
 // We use the `ephemeris` package to find the text-files beneath a given
-// root-directory, and then interate over them in various ways to build up:
+// root-directory, and its `Generator` to iterate over them in various ways
+// to build up:
 //
 // * The blog-entries themselves
 //
@@ -13,23 +14,21 @@
 // * The index & RSS feed.
 //
 // The way that the system is setup the most recent post will allow
-// comments to be submitted upon it - all others will be read-only.
+// comments to be submitted upon it - all others will be read-only, unless
+// a comment-period is configured, or a post has a "Comments:" header.
 package main
 
 import (
+	"context"
 	"embed"
 	"flag"
 	"fmt"
-	"html"
 	"io/fs"
-	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
-	"sort"
 	"strings"
-	"sync"
 	"text/template"
 	"time"
 
@@ -49,22 +48,20 @@ var TEMPLATES embed.FS
 // Variables which are reused live here.
 //
 
-// We generate a static-version (read:embedded copy) of all the
-// template-files beneath `data/`.
-//
-// We load each of these as golang templates here, so that they
-// are globally available and we don't have to re-read/re-parse them
-// more than once.
-//
-// These are stored as part of the build-process, in the `TEMPLATES`
-// variable above.
-var tmpl *template.Template
-
 // We load a JSON configuration file when we launch, which contains
 // the mandatory settings.  We make this configuration object global
 // to access those variables even though that is a bad design.
 var config Config
 
+// generated records the files written by this build, so that any others
+// within the output-directory can be removed once it is complete.
+var generated ephemeris.Manifest
+
+// buildPath is the directory the build is written to.  This is the
+// output-directory, unless atomic builds are enabled, in which case it
+// is a new directory which replaces the output once the build succeeds.
+var buildPath string
+
 // mkdirIfMissing makes a directory, if it is missing.
 //
 // The overhead of calling `stat` probably makes it cheaper to just
@@ -75,142 +72,38 @@ func mkdirIfMissing(path string) {
 	}
 }
 
-// loadTemplates returns a collection of all the templates we have
-// embedded within our application.
-//
-// In addition to loading the templates we also populate a function-map,
-// to allow various functions to be made available to all templates.
-//
-// The functions defined are:
-//
-// ISO8601          - Needed for RSS generation.
-// LOWER            - Lower-case a string.  Used for link-generation.
-// ESCAPE           - Escape HTML-text for RSS_generation too.
-// RECENT_POST_DATE - The date format used for the "most recent entries" list in the sidebar.
-// BLOG_POST_DATE   - The format used in the index/archive/tag-view.
+// loadTemplates returns the templates of our theme, which are either
+// those embedded within our application, or those beneath the ThemePath.
 func loadTemplates() (*template.Template, error) {
 
-	// Create a helper-template, with no name.
-	t := template.New("").Funcs(template.FuncMap{
-
-		// Date-format for RSS feed
-		"ISO8601": func(d time.Time) string {
-			return (fmt.Sprintf("%v", d.Format(time.RFC3339)))
-		},
-
-		// Escape HTML in RSS feed
-		"ESCAPE": func(in string) string {
-			return (html.EscapeString(in))
-		},
-
-		// Escape link name.
-		"ESCAPE_LINK": func(in string) string {
-			return (url.PathEscape(in))
-		},
-
-		// Convert a string to lower-case.
-		//
-		// This is used to make sure all links point to their
-		// lower-cased version of the URL.
-		//
-		"LOWER": func(in string) string {
-			return (strings.ToLower(in))
-		},
-
-		// Prefix of the blog - i.e. URL to prepend to links
-		"PREFIX": func() string {
-			return config.Prefix
-		},
-
-		// Date used on "recent posts"
-		"RECENT_POST_DATE": func(d time.Time) string {
-			year, month, day := d.Date()
-			return (fmt.Sprintf("%d %s %d", day, month.String(), year))
-		},
-
-		// Date used on all blog posts.
-		"BLOG_POST_DATE": func(d time.Time) string {
-			year, month, day := d.Date()
-			return (fmt.Sprintf("%d %s %d %02d:%02d", day, month.String(), year, d.Hour(), d.Minute()))
-		},
-
-		// Date used on comments.
-		"COMMENT_POST_DATE": func(d time.Time) string {
-			year, month, day := d.Date()
-			return (fmt.Sprintf("at %02d:%02d on %d %s %d", d.Hour(), d.Minute(), day, month.String(), year))
-		},
-	})
-
-	//
-	// We're either going to walk a theme-directory, which is
-	// a local directory hierarchy, or we're going to walk
-	// the embedded resources.
-	//
-	//
-	// Default to the embedded resources
-	var arg fs.FS
-	arg = TEMPLATES
+	// Default to the embedded resources, stripping the "data/"
+	// prefix.
+	theme, err := fs.Sub(TEMPLATES, "data")
+	if err != nil {
+		return nil, err
+	}
 
 	// But if we have a path then use that.
 	if config.ThemePath != "" {
-		arg = os.DirFS(config.ThemePath)
+		theme = os.DirFS(config.ThemePath)
 	}
 
-	// Now load all the templates
-	err := fs.WalkDir(arg, ".", func(pth string, d fs.DirEntry, err error) error {
-		// Error?  Then return it
-		if err != nil {
-			return err
-		}
-
-		// Directory?  Ignore it.
-		if d.IsDir() {
-			return nil
-		}
-
-		//
-		// Contents of the template we're loading.
-		//
-		var data []byte
-
-		// Get the contents of the file.
-		//
-		// Either from the local-path
-		if config.ThemePath != "" {
-
-			// Complete path
-			complete := path.Join(config.ThemePath, pth)
-
-			// Read the file contents
-			data, err = os.ReadFile(complete)
-			if err != nil {
-				return err
-			}
-		} else {
-
-			// Or from the embedded resource.
-			data, err = TEMPLATES.ReadFile(pth)
-			if err != nil {
-				return err
-			}
-
-			// However if we're loading from the
-			// embedded resource we need to strip
-			// the "data/" prefix.
-			pth = strings.TrimPrefix(pth, "data/")
-		}
-
-		// Add the data + template
-		t = t.New(pth)
-		t, err = t.Parse(string(data))
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+	return ephemeris.LoadTemplates(theme, generatorConfig())
+}
 
-	return t, err
+// generatorConfig returns the settings of the generated site, from our
+// configuration.
+func generatorConfig() ephemeris.GeneratorConfig {
+	return ephemeris.GeneratorConfig{
+		Prefix:         config.Prefix,
+		CommentAPI:     config.CommentAPI,
+		WebmentionAPI:  config.WebmentionAPI,
+		AddComments:    config.AddComments,
+		RecentComments: config.RecentComments,
+		RelatedPosts:   config.RelatedPosts,
+		Robots:         config.Robots,
+		Jobs:           config.Jobs,
+	}
 }
 
 // exportDefaultTheme iterates over each of our template-resources and writes
@@ -259,765 +152,587 @@ func exportDefaultTheme(prefix string) {
 	}
 }
 
-// outputTags writes out the tag-specific pages.
+// outputStatic copies the static files into the output, and returns
+// them.
 //
-// First of all build up a list of tags, then render
-// a template for each one.
-func outputTags(posts []ephemeris.BlogEntry, recentPosts []ephemeris.BlogEntry) error {
+// These come from the `static/` directory of the theme, the files which
+// are co-located with posts and pages, and the `StaticPath` directory, in
+// that order, so that later sources override earlier ones.
+func outputStatic(output ephemeris.OutputFS) (*ephemeris.StaticFiles, error) {
 
-	//
-	// OK we'll now try to build up a list of tags.
-	//
-	// The tag list will consist of:
-	//
-	//   tagName -> [array-index-1, array-index-2, ...]
-	//
-	// Where the array indexes are the indexes of the posts
-	// array which contain the tag.
-	//
-	// We do this because it is lightweight.
-	//
-	tagMap := make(map[string][]int)
+	var files ephemeris.StaticFiles
 
 	//
-	// For each post ..
+	// The theme's assets.
 	//
-	for i, e := range posts {
-
-		// If it has tags ..
-		if len(e.Tags) > 0 {
-
-			// For each tag
-			for _, tag := range e.Tags {
-
-				// Add the entry-number to the tag-list
-				existing := tagMap[tag]
-				existing = append(existing, i)
-				tagMap[tag] = existing
-			}
+	var theme fs.FS
+	if config.ThemePath != "" {
+		theme = os.DirFS(filepath.Join(config.ThemePath, ephemeris.StaticDirectory))
+	} else {
+		sub, err := fs.Sub(TEMPLATES, path.Join("data", ephemeris.StaticDirectory))
+		if err != nil {
+			return nil, err
 		}
+		theme = sub
 	}
-
-	//
-	//  Page-Structure for a tag-page view.
-	//
-	//  i.e. /tags/z80
-	//
-	type TagPage struct {
-
-		// Tag contains the name of the tag.
-		Tag string
-
-		// Entries holds entries having the given tag
-		Entries []ephemeris.BlogEntry
-
-		// RecentPosts contains data for our sidebar.
-		RecentPosts []ephemeris.BlogEntry
+	if _, err := fs.Stat(theme, "."); err == nil {
+		err = files.Add(theme, nil)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	//
-	// Create an instance.
-	//
-	var pageData TagPage
-	pageData.RecentPosts = recentPosts
-
-	//
-	// Create a per-page tag-template
+	// Files next to posts and pages, then the static directory.
 	//
-	for key, uses := range tagMap {
-
-		mkdirIfMissing(filepath.Join(config.OutputPath, "tags", key))
-
-		// Empty the tags from the previous run
-		pageData.Entries = nil
-		pageData.Tag = key
-
-		// Add the entries
-		for _, e := range uses {
-			pageData.Entries = append(pageData.Entries, posts[e])
+	sources := []string{config.PostsPath, config.PagesPath, config.StaticPath}
+	for i, dir := range sources {
+		if dir == "" {
+			continue
 		}
 
-		// Sort by date - tags will be viewed in creation-order
-		sort.Slice(pageData.Entries, func(i, j int) bool {
-			a := pageData.Entries[i].Date
-			b := pageData.Entries[j].Date
-			return a.Before(b)
-		})
-
-		//
-		// Create the output file.
-		//
-		output, err := os.Create(filepath.Join(config.OutputPath, "tags", key, "index.html"))
-		if err != nil {
-			return err
+		// Only assets, such as images, are copied from alongside
+		// posts and pages.  The output, the caches, and comments
+		// aren't copied if they're beneath the source.
+		skip := func(name string) bool {
+			if i < 2 && !ephemeris.IsAsset(name) {
+				return true
+			}
+			file := filepath.Join(dir, name)
+			return beneath(config.OutputPath, file) ||
+				(config.AtomicBuilds && beneath(builds().Dir(), file)) ||
+				(config.PostCachePath != "" && beneath(config.PostCachePath, file)) ||
+				(config.ImageCachePath != "" && beneath(config.ImageCachePath, file)) ||
+				(config.CommentsPath != "" && beneath(config.CommentsPath, file)) ||
+				(config.PendingPath != "" && beneath(config.PendingPath, file))
 		}
 
-		//
-		// Render the template into our file.
-		//
-		err = tmpl.ExecuteTemplate(output, "tag_page.tmpl", pageData)
+		err := files.Add(os.DirFS(dir), skip)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		output.Close()
 	}
 
-	//
-	// /tags/index.html
-	//
-
-	//
-	// We want a sorted list of tags
-	//
-	var tagNames []string
-	for key := range tagMap {
-		tagNames = append(tagNames, key)
+	for _, name := range files.Names() {
+		generated.Add(name)
 	}
-	sort.Strings(tagNames)
 
-	//
-	// We want to have a tag-cloud for the /tags/index.html page.
-	//
-	type TagMap struct {
-		// Tag contains the name of the string.
-		Tag string
-
-		// TSize contains the text-size of the entry in the cloud.
-		TSize int
-
-		// Count shows how many times the tag was used.
-		Count int
-	}
+	_, err := files.Copy(output)
+	return &files, err
+}
 
-	//
-	// Page-data: The tag-data, and the recent-posts list.
-	//
-	type TagCloudPage struct {
-		Tags        []TagMap
-		RecentPosts []ephemeris.BlogEntry
-	}
-	var tagCloud TagCloudPage
-	tagCloud.RecentPosts = recentPosts
+// processImages generates resized variants of the images referenced by
+// posts and pages, and rewrites their content to use them.
+//
+// The images are read from the static files, and their variants written
+// alongside them in the output.
+func processImages(site *ephemeris.Ephemeris, pages []ephemeris.Page, files *ephemeris.StaticFiles, output ephemeris.OutputFS) error {
 
-	//
-	// Now we have a sorted list of unique tag-names we can build up
-	// that array for the template-page
-	//
-	for _, tag := range tagNames {
-		count := len(tagMap[tag])
-		size := (count * 5) + 5
-		if size > 60 {
-			size = 60
-		}
-		tagCloud.Tags = append(tagCloud.Tags, TagMap{Tag: tag, Count: count, TSize: size})
+	p := &ephemeris.ImageProcessor{
+		Widths:    config.ImageWidths,
+		Quality:   config.ImageQuality,
+		CachePath: config.ImageCachePath,
+		Source:    files,
+		Output:    output,
+		Prefix:    config.Prefix,
 	}
 
-	//
-	// Create the output file
-	//
-	ti, err := os.Create(filepath.Join(config.OutputPath, "tags", "index.html"))
+	err := site.RewriteImages(p)
 	if err != nil {
 		return err
 	}
 
-	//
-	// Render the template into our file.
-	//
-	err = tmpl.ExecuteTemplate(ti, "tags.tmpl", tagCloud)
-	if err != nil {
-		return err
+	for i := range pages {
+		pages[i].Content, err = p.Rewrite(pages[i].Content, path.Dir(filepath.ToSlash(pages[i].Output)))
+		if err != nil {
+			return err
+		}
 	}
-	ti.Close()
 
+	for _, name := range p.Files() {
+		generated.Add(name)
+	}
 	return nil
 }
 
-// output a year/month page for each distinct period in which we have posts.
-func outputArchive(posts []ephemeris.BlogEntry, recentPosts []ephemeris.BlogEntry) error {
-
-	//
-	// We'll build up a list of year/mon pages.
-	//
-	// The map will consist of:
-	//
-	//   year/mon -> [array-index-1, array-index-2, ...]
-	//
-	// Where the array indexes are the indexes of the posts
-	// array which were posted in the given month.
-	//
-	archiveMap := make(map[string][]int)
+// beneath returns true if the given path is the directory, or is beneath
+// it.
+func beneath(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
 
-	for i, e := range posts {
+// outputIgnore returns the patterns matching the files within the output
+// which aren't generated, and so must be kept.
+func outputIgnore() []string {
 
-		// The key is "YYYY/NN"
-		key := e.Year() + "/" + e.MonthNumber()
+	ignore := config.OutputIgnore
 
-		existing := archiveMap[key]
-		existing = append(existing, i)
-		archiveMap[key] = existing
+	// Don't remove the caches, if they live in the output.
+	for _, cache := range []string{config.ImageCachePath, config.PostCachePath} {
+		if cache != "" && beneath(config.OutputPath, cache) {
+			rel, _ := filepath.Rel(config.OutputPath, cache)
+			ignore = append(ignore, filepath.ToSlash(rel))
+		}
 	}
+	return ignore
+}
 
-	//
-	// Archive page contains data to be shown in
-	// the archive page of:
-	//
-	//  /archive/2019/03/
-	//
-	type PageData struct {
-
-		// Year contains the year we're covering.
-		Year string
-
-		// Month contains the month we're covering.
-		Month string
+// builds returns the helper which manages atomic builds.
+func builds() *ephemeris.Builds {
+	return &ephemeris.Builds{Output: config.OutputPath, Keep: config.KeepBuilds}
+}
 
-		// Entries holds the entries in the given year/month
-		Entries []ephemeris.BlogEntry
+// checkOutputPath returns an error if the output-directory is, or
+// contains, any of the directories we read from, since removing the
+// stale files within it might then remove posts, or comments.
+func checkOutputPath() error {
 
-		// RecentPosts holds data for the sidebar.
-		RecentPosts []ephemeris.BlogEntry
+	sources := []struct{ key, dir string }{
+		{"PostsPath", config.PostsPath},
+		{"CommentsPath", config.CommentsPath},
+		{"PendingPath", config.PendingPath},
+		{"PagesPath", config.PagesPath},
+		{"StaticPath", config.StaticPath},
+		{"ThemePath", config.ThemePath},
+		{"ImageCachePath", config.ImageCachePath},
+		{"PostCachePath", config.PostCachePath},
 	}
 
-	//
-	// Create an instance of the object.
-	//
-	var pageData PageData
-	pageData.RecentPosts = recentPosts
+	output, err := filepath.Abs(config.OutputPath)
+	if err != nil {
+		return err
+	}
 
-	//
-	// Create a per-page output
-	//
-	for key, uses := range archiveMap {
-
-		mkdirIfMissing(filepath.Join(config.OutputPath, "archive", key))
-
-		// Empty the tags from the previous run
-		pageData.Entries = nil
-
-		// Add the entries
-		for _, e := range uses {
-			pageData.Entries = append(pageData.Entries, posts[e])
-
-			//
-			// Our archiveMap contains keys of the form:
-			//
-			//    year/mon
-			//
-			// But when we present this to the viewers we want
-			// to show the month-name, and year name.
-			//
-			// We can calculate that from the `Date` field
-			// in the post itself :)
-			//
-			pageData.Year = posts[e].Year()
-			pageData.Month = posts[e].MonthName()
+	for _, src := range sources {
+		if src.dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(src.dir)
+		if err != nil {
+			return err
+		}
+		if beneath(output, abs) {
+			return fmt.Errorf("OutputPath %s contains the %s %s, so stale files can't be removed safely", config.OutputPath, src.key, src.dir)
 		}
+	}
+	return nil
+}
 
-		// Sort by date - posts will be in order they've been written
-		sort.Slice(pageData.Entries, func(i, j int) bool {
-			a := pageData.Entries[i].Date
-			b := pageData.Entries[j].Date
-			return a.Before(b)
-		})
+// removeStaleOutput removes the files within the output-directory which
+// the previous build generated, but this one didn't, such as the pages
+// of deleted tags, if enabled.
+//
+// The files this build generated are then recorded, for the next build.
+func removeStaleOutput(remove bool) error {
 
-		//
-		// Create the output file.
-		//
-		output, err := os.Create(filepath.Join(config.OutputPath, "archive", key, "index.html"))
+	if remove {
+		previous, err := ephemeris.LoadManifest(buildPath)
 		if err != nil {
 			return err
 		}
 
-		//
-		// Render the template into it.
-		//
-		err = tmpl.ExecuteTemplate(output, "archive_page.tmpl", pageData)
+		stale, err := generated.Stale(buildPath, previous, outputIgnore())
+		if err != nil {
+			return err
+		}
+		if len(stale) > 0 {
+			fmt.Printf("Removed %d stale files.\n", len(stale))
+		}
+		err = ephemeris.RemoveStale(buildPath, stale)
 		if err != nil {
 			return err
 		}
-		output.Close()
 	}
 
-	//
-	// Page data for the archive-index.
-	//
-	//  i.e. /archive/index.html
-	//
-	type ArchiveCount struct {
-		Year      string
-		Month     string
-		MonthName string
-		Count     string
-	}
+	return generated.Save(buildPath)
+}
 
-	mappy := make(map[string][]ArchiveCount)
+// reportStaleOutput reports the files within the output-directory which
+// the previous build generated, but this one didn't, and so would be
+// removed if RemoveStale were enabled.
+//
+// Nothing is reported if no build has recorded its files yet.
+func reportStaleOutput() error {
 
-	type ArchiveIndex struct {
-		Year        string
-		Data        []ArchiveCount
-		RecentPosts []ephemeris.BlogEntry
+	previous, err := ephemeris.LoadManifest(config.OutputPath)
+	if err != nil {
+		return err
 	}
-	var ai []ArchiveIndex
-
-	//
-	// Build up the count of posts in the given month/year.
-	//
-	for _, e := range archiveMap {
-
-		//
-		// Since all the posts are have the same year + month
-		// pair we're able to just use the first entry in
-		// each returned set.
-		//
-		y := posts[e[0]].Year()
-		m := posts[e[0]].MonthNumber()
-		n := posts[e[0]].MonthName()
-		c := fmt.Sprintf("%d", len(e))
-
-		// Append
-		existing := mappy[y]
-		existing = append(existing, ArchiveCount{
-			Count:     c,
-			Year:      y,
-			Month:     m,
-			MonthName: n,
-		})
-
-		mappy[y] = existing
+	if len(previous.Files()) == 0 {
+		fmt.Printf("No earlier build has been recorded in %s, so nothing would be removed.\n", config.OutputPath)
+		return nil
 	}
 
-	// Sort the entries now we've generated them.
-	for k, entries := range mappy {
+	stale, err := generated.Stale(config.OutputPath, previous, outputIgnore())
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		fmt.Printf("Would remove %s\n", filepath.Join(config.OutputPath, name))
+	}
+	fmt.Printf("%d stale files would be removed.\n", len(stale))
+	return nil
+}
 
-		vals := mappy[k]
-		sort.Slice(entries, func(i, j int) bool {
-			return entries[i].Month < entries[j].Month
+// newSite loads the blog-posts, and comments, using our configuration.
+func newSite() (*ephemeris.Ephemeris, error) {
+	return ephemeris.NewWithOptions(config.PostsPath, config.CommentsPath, config.Prefix,
+		ephemeris.Options{
+			Avatars:         config.Avatars,
+			AvatarSalt:      config.AvatarSalt,
+			AnonymizeIP:     config.AnonymizeIP,
+			AnonymizeSecret: config.AnonymizeSecret,
+			CommentDays:     config.CommentDays,
+			Jobs:            config.Jobs,
+			CachePath:       config.PostCachePath,
 		})
-		mappy[k] = vals
+}
 
+// main is our entry-point.
+func main() {
+	err := run()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
 	}
+}
+
+// run parses our command-line, and then generates the blog, or runs the
+// requested sub-command or server.
+func run() error {
 
 	//
-	// Now a data-structure
+	// Command-line arguments which are accepted.
 	//
-	// sorted keys
+	allowComments := flag.Bool("allow-comments", true, "Enable comments to be added to entries which accept them.")
+	dryRun := flag.Bool("dry-run", false, "Generate the blog in memory, without writing or publishing it, and report the stale files which RemoveStale would remove from the output-directory.")
+	confFile := flag.String("config", "ephemeris.json", "The path to our configuration file.")
+	exportTheme := flag.String("export-theme", "", "Export the default theme to a local directory.")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "The number of posts to parse, and pages to render, at once.")
+	outputPath := flag.String("output", "", "Write the blog to this directory, or archive (.tar, .tar.gz, .tgz, or .zip), rather than the OutputPath.")
+	previewAddr := flag.String("preview", "", "Generate the blog in memory, and serve it on the given address, e.g. ':8000', rather than writing it.")
+	sendMentions := flag.Bool("send-webmentions", false, "Send webmentions for the links in each entry, after generation.")
+	server := flag.String("server", "", "Run a server to receive comments and webmentions on the given address, e.g. ':8080'.")
+
 	//
-	var years []string
-	for year := range mappy {
-		years = append(years, year)
-	}
-	sort.Strings(years)
+	// Parse the flags.
+	//
+	flag.Parse()
 
 	//
-	// For each year we add the data
+	// Exporting the theme?
 	//
-	for _, year := range years {
-		ai = append(ai, ArchiveIndex{Year: year, Data: mappy[year], RecentPosts: recentPosts})
+	if *exportTheme != "" {
+		exportDefaultTheme(*exportTheme)
+		return nil
 	}
 
 	//
-	// Create the output file.
+	// Record our start-time
 	//
-	ar, err := os.Create(filepath.Join(config.OutputPath, "archive", "index.html"))
-	if err != nil {
-		return err
-	}
+	start := time.Now()
 
 	//
-	// Render the template into our file.
+	// Load our configuration file (JSON)
 	//
-	err = tmpl.ExecuteTemplate(ar, "archive.tmpl", ai)
+	var err error
+	config, err = loadConfig(*confFile)
 	if err != nil {
-		return err
-	}
-	ar.Close()
-	return nil
-}
-
-// outputIndex outputs the /index.html file.
-//
-// We don't need to sort, or limit ourselves here, because we only use
-// the "most recent posts" we've already discovered.
-func outputIndex(posts []ephemeris.BlogEntry, recentPosts []ephemeris.BlogEntry) error {
-
-	mkdirIfMissing(config.OutputPath)
-
-	// Page-structure for the site.
-	type Recent struct {
-
-		// Entries has the most recent entries.
-		Entries []ephemeris.BlogEntry
-
-		// RecentPosts has the same data, but for
-		// the side-bar.  It is redundant.
-		RecentPosts []ephemeris.BlogEntry
+		return fmt.Errorf("failed to load configuration file %s %s", *confFile, err.Error())
 	}
 
 	//
-	// The data we'll store for the page.
-	//
-	// Our front-page shows the same number of posts as
-	// the recent-list in the sidebar, so we don't need
-	// to do anything special here, we show the same
-	// list for both of them.
+	// Setup defaults if missing
 	//
-	var pageData Recent
-	pageData.Entries = recentPosts
-	pageData.RecentPosts = recentPosts
+	config.setDefaults()
 
 	//
-	// Create the output file.
+	// Running a sub-command?
 	//
-	output, err := os.Create(filepath.Join(config.OutputPath, "index.html"))
-	if err != nil {
-		return err
+	if flag.NArg() > 0 {
+		return runCommand(flag.Args())
 	}
 
 	//
-	// Render the template into our file.
+	// Receiving comments and webmentions?
 	//
-	err = tmpl.ExecuteTemplate(output, "index.tmpl", pageData)
-	if err != nil {
-		return err
+	if *server != "" {
+		err = serve(*server)
+		if err != nil {
+			return fmt.Errorf("error running server: %s", err.Error())
+		}
+		return nil
 	}
-	output.Close()
-
-	return nil
 
-}
-
-// outputRSS outputs the /index.rss file.
-//
-// We don't need to sort, or limit ourselves here, because we only use
-// the "most recent posts" we've already discovered.
-func outputRSS(posts []ephemeris.BlogEntry, recentPosts []ephemeris.BlogEntry) error {
-
-	mkdirIfMissing(config.OutputPath)
-
-	// Page-structure for the site.
-	type Recent struct {
-
-		// Entries has the most recent entries.
-		Entries []ephemeris.BlogEntry
-
-		// RecentPosts has the same data, but for
-		// the side-bar.  It is redundant.
-		RecentPosts []ephemeris.BlogEntry
-	}
+	//
+	// Preserve comment setting, and the number of jobs.
+	//
+	config.AddComments = *allowComments
+	config.Jobs = *jobs
 
 	//
-	// The data we'll store for the page.
+	// Writing to a different directory, or an archive?
 	//
-	// Our front-page shows the same number of posts as
-	// the recent-list in the sidebar, so we don't need
-	// to do anything special here, we show the same
-	// list for both of them.
+	// Stale files are never removed from a directory given on
+	// the command-line, since it might hold anything.
 	//
-	var pageData Recent
-	pageData.Entries = recentPosts
-	pageData.RecentPosts = recentPosts
+	archivePath := ""
+	if ephemeris.IsArchive(*outputPath) {
+		archivePath = *outputPath
+	} else if *outputPath != "" {
+		config.OutputPath = *outputPath
+		config.RemoveStale = false
+	}
 
 	//
-	// Create the output file.
+	// A dry-run reports upon the output-directory, so there must
+	// be one.
 	//
-	rss, err := os.Create(filepath.Join(config.OutputPath, "index.rss"))
-	if err != nil {
-		return err
+	if *dryRun && (archivePath != "" || *previewAddr != "") {
+		return fmt.Errorf("-dry-run can't be used with -preview, or when writing an archive")
 	}
 
 	//
-	// Render the template into it.
+	// When previewing the links within the blog must point to the
+	// preview, rather than to the live blog.
 	//
-	err = tmpl.ExecuteTemplate(rss, "index.rss", pageData)
-	if err != nil {
-		return err
+	if *previewAddr != "" {
+		config.Prefix = previewPrefix(*previewAddr)
 	}
-	rss.Close()
-
-	return nil
-
-}
-
-// Output one page for each entry.
-//
-// If comments are enabled then we'll add the comments to the entries,
-// and we'll ensure we setup the comment CGI path.
-func outputEntries(posts []ephemeris.BlogEntry, recentPosts []ephemeris.BlogEntry) error {
-
-	mkdirIfMissing(config.OutputPath)
-
-	// Page-structure for the site.
-	type Recent struct {
 
-		// The blog-entry
-		Entry ephemeris.BlogEntry
-
-		// Should we display the add-comment form for this post?
-		AddComment bool
-
-		// CGI link
-		CommentAPI string
-
-		// The recent posts for the sidebar.
-		RecentPosts []ephemeris.BlogEntry
+	//
+	// Refuse to remove stale files if that might remove our
+	// sources.
+	//
+	if config.RemoveStale {
+		err = checkOutputPath()
+		if err != nil {
+			return err
+		}
 	}
 
 	//
-	// The data we use for output.
+	// Create an object to generate our blog from
 	//
-	var pageData Recent
-
-	// The most recent posts
-	pageData.RecentPosts = recentPosts
-	pageData.AddComment = false
-
-	// The site prefix, and the link to the CGI form for
-	// comment-submission.
-	pageData.CommentAPI = config.CommentAPI
+	site, err := newSite()
+	if err != nil {
+		return fmt.Errorf("failed to create site: %s", err.Error())
+	}
 
 	//
-	// Create a per-page output
+	// Load any standalone pages.
 	//
-	for _, entry := range posts {
-
-		//
-		// Populate the page-data with this entry.
-		//
-		pageData.Entry = entry
-
-		//
-		// The most recent post has comments enabled,
-		// all others do not.
-		//
-		pageData.AddComment = config.AddComments && (entry.Path == recentPosts[0].Path)
-
-		//
-		// We have a link and that points to a filename.
-		//
-		// We get the latter from the former by removing the
-		// prefix.
-		//
-		u, err := url.Parse(entry.Link)
+	var pages []ephemeris.Page
+	if config.PagesPath != "" {
+		pages, err = ephemeris.LoadPages(config.PagesPath, config.Prefix)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to load pages: %s", err.Error())
 		}
+	}
 
-		// Get the path.
-		path := u.RequestURI()
+	//
+	// Choose where the blog is written to.  This is a directory,
+	// unless we're previewing the blog, or writing an archive.
+	//
+	var output ephemeris.OutputFS
+	var preview *ephemeris.MemoryOutput
+	var archive *ephemeris.Archive
+	var archiveFile *os.File
+	published := false
 
-		// Remove the leading slash.
-		path = strings.TrimPrefix(path, "/")
+	switch {
+	case *previewAddr != "":
+		preview = &ephemeris.MemoryOutput{}
+		output = preview
 
-		//
-		// Lower-case PATH and write to that too
-		//
-		dest := strings.ToLower(path)
+	case *dryRun:
+		// Nothing is written, but the files which would be are
+		// still recorded.
+		output = &ephemeris.MemoryOutput{}
 
-		//
-		// Create the output file.
-		//
-		output, err := os.Create(filepath.Join(config.OutputPath, dest))
+	case archivePath != "":
+		// Write to a temporary file, which replaces the archive
+		// once it is complete.
+		archiveFile, err = os.Create(archivePath + ".tmp")
 		if err != nil {
-			return err
+			return fmt.Errorf("error creating archive: %s", err.Error())
 		}
+		defer func() {
+			if !published {
+				archiveFile.Close()
+				os.Remove(archiveFile.Name())
+			}
+		}()
 
-		//
-		// Render the template into it.
-		//
-		err = tmpl.ExecuteTemplate(output, "entry.tmpl", pageData)
+		archive, err = ephemeris.NewArchive(archiveFile, archivePath)
 		if err != nil {
-			return err
+			return fmt.Errorf("error creating archive: %s", err.Error())
 		}
-		output.Close()
-
-		//
-		// Create symlink
-		//
-		os.Symlink(dest, filepath.Join(config.OutputPath, path))
+		output = archive
+
+	default:
+		// Write to a new build, if atomic builds are enabled,
+		// which replaces the output once it is complete.
+		buildPath = config.OutputPath
+		if config.AtomicBuilds {
+			buildPath, err = builds().Stage(outputIgnore())
+			if err != nil {
+				return fmt.Errorf("error creating build: %s", err.Error())
+			}
 
+			// Remove the build if it fails.
+			defer func() {
+				if !published {
+					builds().Discard(buildPath)
+				}
+			}()
+		}
+		mkdirIfMissing(buildPath)
+		output = ephemeris.DirOutput(buildPath)
 	}
 
-	return nil
-
-}
-
-// main is our entry-point.
-func main() {
-
-	//
-	// Command-line arguments which are accepted.
-	//
-	allowComments := flag.Bool("allow-comments", true, "Enable comments to be added to the most recent entry.")
-	confFile := flag.String("config", "ephemeris.json", "The path to our configuration file.")
-	exportTheme := flag.String("export-theme", "", "Export the default theme to a local directory.")
-
-	//
-	// Parse the flags.
-	//
-	flag.Parse()
-
 	//
-	// Exporting the theme?
+	// Copy any static files, before the images they contain
+	// are processed.
 	//
-	if *exportTheme != "" {
-		exportDefaultTheme(*exportTheme)
-		return
+	files, err := outputStatic(output)
+	if err != nil {
+		return fmt.Errorf("error copying static files: %s", err.Error())
 	}
 
 	//
-	// Record our start-time
-	//
-	start := time.Now()
-
-	//
-	// Load our configuration file (JSON)
+	// Posts, and pages, are written to different directories than
+	// they're read from, so fix the links to the files alongside
+	// them.
 	//
-	var err error
-	config, err = loadConfig(*confFile)
-	if err != nil {
-		fmt.Printf("Failed to load configuration file %s %s\n", *confFile, err.Error())
-		return
+	site.RewriteStatic(files)
+	for i, page := range pages {
+		source := "."
+		if rel, err := filepath.Rel(config.PagesPath, filepath.Dir(page.Path)); err == nil {
+			source = filepath.ToSlash(rel)
+		}
+		output := path.Dir(filepath.ToSlash(page.Output))
+		pages[i].Content = files.Rewrite(page.Content, source, output, config.Prefix)
 	}
 
 	//
-	// Setup defaults if missing
+	// Resize the images within posts and pages, if enabled.
 	//
-	if config.OutputPath == "" {
-		config.OutputPath = "output"
-	}
-	if config.PostsPath == "" {
-
-		// Migration of legacy key-name
-		if config.Posts != "" {
-			config.PostsPath = config.Posts
-		} else {
-			config.PostsPath = "data/"
-		}
-	}
-	if config.CommentsPath == "" {
-		// Migration of legacy key-name
-		if config.Comments != "" {
-			config.CommentsPath = config.Comments
-		} else {
-			config.CommentsPath = "comments/"
+	if len(config.ImageWidths) > 0 {
+		err = processImages(site, pages, files, output)
+		if err != nil {
+			return fmt.Errorf("error processing images: %s", err.Error())
 		}
 	}
 
 	//
-	// Preserve comment setting, and theme-path
-	//
-	config.AddComments = *allowComments
-
+	// We can now load the collection of templates, either those
+	// embedded within our application, or those of our theme.
 	//
-	// Create an object to generate our blog from
+	// Our templates are loaded en masse, and each one of them
+	// has some (custom/bonus/extra) functions available to them.
 	//
-	site, err := ephemeris.New(config.PostsPath, config.CommentsPath, config.Prefix)
+	tmpl, err := loadTemplates()
 	if err != nil {
-		fmt.Printf("Failed to create site: %s\n", err.Error())
-		return
+		return fmt.Errorf("error loading embedded resources: %s", err.Error())
 	}
 
 	//
-	// Get all the entries, and the recent entries too.
+	// Create the generator, which finds the recent entries, the
+	// related entries, and so on.
 	//
-	entries := site.Entries()
-	recent := site.Recent(10)
+	generator := ephemeris.NewGenerator(site, pages, generatorConfig(), tmpl, output)
+	generator.Manifest = &generated
 
 	//
 	// Show the number of blog-posts we processed.
 	//
+	entries := site.Entries()
 	fmt.Printf("Read %d blog posts.\n", len(entries))
 
-	//
-	// We can now load the collection of templates which we've stored
-	// in `static.go`.
-	//
-	// Our templates are loaded en masse, and each one of them
-	// has some (custom/bonus/extra) functions available to them.
-	//
-	tmpl, err = loadTemplates()
-	if err != nil {
-		fmt.Printf("Error loading embedded resources: %s\n", err.Error())
-		return
-	}
-
-	//
-	// We're going to run the page-generation in a series of threads
-	// now.  So we'll add a synchronizer here.
-	//
-	var wg sync.WaitGroup
-
 	//
 	// Ensure we use all the CPU we have available.
 	//
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	// We're going to wait for all our routines to be complete,
-	// fixed number here, as added below:
-	wg.Add(5)
-
 	//
-	// Output tag-cloud, and per-tag pages.
+	// Generate the blog.  Each part of the blog is generated in its
+	// own goroutine, and if one fails the others are cancelled, and
+	// we report every failure once they've all stopped.
 	//
-	go func() {
-		err := outputTags(entries, recent)
+	err = generator.Generate(context.Background())
+	if err != nil {
+		return fmt.Errorf("error rendering the blog:\n%s", err.Error())
+	}
+
+	switch {
+	case preview != nil:
+		//
+		// Serve the blog, which exists only in memory.
+		//
+		return serveSite(*previewAddr, preview)
+
+	case archive != nil:
+		//
+		// Complete the archive, and move it into place.
+		//
+		err = archive.Close()
+		if err == nil {
+			err = archiveFile.Close()
+		}
+		if err == nil {
+			err = os.Rename(archiveFile.Name(), archivePath)
+		}
 		if err != nil {
-			fmt.Printf("Error rendering tag-pages:%s\n", err.Error())
-			os.Exit(1)
+			return fmt.Errorf("error writing archive: %s", err.Error())
 		}
-		wg.Done()
-	}()
+		published = true
+		fmt.Printf("Wrote %s.\n", archivePath)
 
-	//
-	// Output the per year/month archive, and the archive-index.
-	//
-	go func() {
-		err := outputArchive(entries, recent)
+	case *dryRun:
+		//
+		// Report what would be removed, but leave the output,
+		// and the record of the previous build, alone.
+		//
+		err = reportStaleOutput()
 		if err != nil {
-			fmt.Printf("Error rendering archive-pages:%s\n", err.Error())
-			os.Exit(1)
+			return fmt.Errorf("error finding stale files: %s", err.Error())
 		}
-		wg.Done()
-	}()
 
-	//
-	// Output index page.
-	//
-	go func() {
-		err := outputIndex(entries, recent)
+	default:
+		//
+		// Remove anything which the previous build generated, but
+		// this one didn't, if enabled.
+		//
+		err = removeStaleOutput(config.RemoveStale)
 		if err != nil {
-			fmt.Printf("Error rendering index.html: %s\n", err.Error())
-			os.Exit(1)
+			return fmt.Errorf("error removing stale files: %s", err.Error())
 		}
-		wg.Done()
-	}()
 
-	//
-	// Output RSS feed which has the same information as the index-page.
-	//
-	go func() {
-		err := outputRSS(entries, recent)
-		if err != nil {
-			fmt.Printf("Error rendering /index.rss: %s\n", err.Error())
-			os.Exit(1)
+		//
+		// Make the build live.
+		//
+		if config.AtomicBuilds {
+			err = builds().Publish(buildPath)
+			if err != nil {
+				return fmt.Errorf("error publishing build: %s", err.Error())
+			}
+			published = true
 		}
-		wg.Done()
-	}()
+	}
 
 	//
-	// Output each entry.
+	// Send webmentions, now the entries have been generated.
 	//
-	go func() {
-		err := outputEntries(entries, recent)
-
+	if *sendMentions && *dryRun {
+		fmt.Printf("Not sending webmentions during a dry-run.\n")
+	} else if *sendMentions {
+		err = sendWebmentions(entries)
 		if err != nil {
-			fmt.Printf("Error rendering blog-posts: %s\n", err.Error())
-			os.Exit(1)
+			fmt.Printf("Error sending webmentions: %s\n", err.Error())
 		}
-		wg.Done()
-	}()
-
-	wg.Wait()
+	}
 
 	//
 	// Report on our runtime
@@ -1025,4 +740,5 @@ func main() {
 	elapsed := time.Since(start)
 	fmt.Printf("Compilation took %s\n", elapsed)
 
+	return nil
 }
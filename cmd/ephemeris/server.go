@@ -0,0 +1,138 @@
+// server.go - Accept comments, and webmentions, over HTTP, and serve
+// previews of the blog.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/skx/ephemeris"
+)
+
+// serve launches a HTTP server which accepts comments at `/comment`, and
+// webmentions at `/webmention`, and stores them beneath the
+// comment-directory.
+//
+// If a moderation-secret is configured comments are held until they're
+// approved, via the links sent to the owner, which are handled at
+// `/moderate`.
+func serve(addr string) error {
+
+	// The blog, which is shared by both receivers.
+	site := reloadingSite(time.Minute)
+
+	comments := &ephemeris.CommentReceiver{
+		Prefix:       config.Prefix,
+		CommentsPath: config.CommentsPath,
+		Site:         site,
+	}
+
+	// Send notifications, if we have a mail-server.
+	if config.SMTPHost != "" {
+		comments.Notifier = &ephemeris.Notifier{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.NotifyFrom,
+			Owner:    config.NotifyOwner,
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/comment", comments)
+
+	if config.ModerationSecret != "" {
+		if config.ModerationURL == "" {
+			return fmt.Errorf("ModerationURL must be set when ModerationSecret is used")
+		}
+
+		err := os.MkdirAll(config.PendingPath, 0755)
+		if err != nil {
+			return err
+		}
+
+		comments.Moderator = &ephemeris.Moderator{
+			Secret:       config.ModerationSecret,
+			URL:          config.ModerationURL,
+			Expiry:       time.Duration(config.ModerationDays) * 24 * time.Hour,
+			Prefix:       config.Prefix,
+			PendingPath:  config.PendingPath,
+			CommentsPath: config.CommentsPath,
+			Notifier:     comments.Notifier,
+		}
+		mux.Handle("/moderate", comments.Moderator)
+
+		// The moderator also signs the links which confirm,
+		// and cancel, subscriptions to new comments.
+		if comments.Notifier != nil {
+			comments.Notifier.Moderator = comments.Moderator
+		}
+	}
+
+	mux.Handle("/webmention", &ephemeris.WebmentionReceiver{
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		Prefix:       config.Prefix,
+		CommentsPath: config.CommentsPath,
+		Notifier:     comments.Notifier,
+		Moderator:    comments.Moderator,
+		Site:         site,
+	})
+
+	fmt.Printf("Accepting comments on http://%s/comment\n", addr)
+	fmt.Printf("Accepting webmentions on http://%s/webmention\n", addr)
+	if comments.Moderator != nil {
+		fmt.Printf("Holding comments, and webmentions, for moderation in %s\n", config.PendingPath)
+	}
+	return http.ListenAndServe(addr, mux)
+}
+
+// reloadingSite returns a function which returns the blog, loading it
+// again once it is older than the given age, so that comments are
+// accepted upon new entries without restarting the server.
+func reloadingSite(age time.Duration) func() (*ephemeris.Ephemeris, error) {
+
+	var mu sync.Mutex
+	var site *ephemeris.Ephemeris
+	var loaded time.Time
+
+	return func() (*ephemeris.Ephemeris, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if site == nil || time.Since(loaded) > age {
+			fresh, err := newSite()
+			if err != nil {
+				return nil, err
+			}
+			site, loaded = fresh, time.Now()
+		}
+		return site, nil
+	}
+}
+
+// serveSite serves a generated site on the given address, such as one
+// generated in memory to preview it.
+func serveSite(addr string, site fs.FS) error {
+	fmt.Printf("Serving the blog on %s\n", previewPrefix(addr))
+	return http.ListenAndServe(addr, http.FileServer(http.FS(site)))
+}
+
+// previewPrefix returns the URL-prefix of a site served on the given
+// address, such as "http://localhost:8000/" for ":8000".
+func previewPrefix(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr + "/"
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
@@ -0,0 +1,61 @@
+// webmention.go - Send and receive webmentions.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skx/ephemeris"
+)
+
+// sendWebmentions notifies the sites we link to that we've done so.
+//
+// Every outbound link in every entry is considered, and those which have
+// already been handled are recorded in a state-file so that rebuilding
+// the blog doesn't resend them.  Links to sites which don't advertise a
+// webmention endpoint are recorded too, so we don't re-fetch them each
+// time.
+func sendWebmentions(posts []ephemeris.BlogEntry) error {
+
+	state, err := ephemeris.LoadWebmentionState(config.WebmentionState)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for _, entry := range posts {
+
+		// Our entries are written to lower-cased paths.
+		source := strings.ToLower(entry.Link)
+
+		for _, target := range ephemeris.OutboundLinks(entry.Content, config.Prefix) {
+
+			if state.HasSent(source, target) {
+				continue
+			}
+
+			endpoint, err := ephemeris.DiscoverWebmentionEndpoint(client, target)
+			if err != nil {
+				fmt.Printf("Failed to discover webmention endpoint for %s: %s\n", target, err.Error())
+				continue
+			}
+
+			if endpoint != "" {
+				err = ephemeris.SendWebmention(client, endpoint, source, target)
+				if err != nil {
+					fmt.Printf("Failed to send webmention to %s: %s\n", endpoint, err.Error())
+					continue
+				}
+				fmt.Printf("Sent webmention for %s to %s\n", target, endpoint)
+			}
+
+			state.MarkSent(source, target, time.Now())
+		}
+	}
+
+	return state.Save()
+}
@@ -0,0 +1,108 @@
+package ephemeris
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skx/headerfile"
+)
+
+// AnonymousAuthor is the name given to comments which have been
+// anonymised.
+const AnonymousAuthor = "Anonymous"
+
+// privateHeaders are the headers of a comment-file which identify the
+// submitter, and are removed when the comment is anonymised.
+var privateHeaders = map[string]bool{
+	"name":       true,
+	"mail":       true,
+	"link":       true,
+	"ip-address": true,
+	"user-agent": true,
+	"notify":     true,
+}
+
+// FindCommentsBy returns the paths of the comment-files, within the
+// given directory, which were submitted with the given email-address
+// or name.
+//
+// Both are compared case-insensitively, and an empty value matches
+// nothing, so that we never select every comment by accident.  Only the
+// headers of each comment-file are read, and those which can't be read
+// are skipped, and returned, rather than failing the whole search.
+func FindCommentsBy(dir string, email string, name string) ([]string, []string, error) {
+
+	email = strings.TrimSpace(email)
+	name = strings.TrimSpace(name)
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result []string
+	var skipped []string
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		headers, err := headerfile.New(path).Headers()
+		if err != nil {
+			skipped = append(skipped, path)
+			continue
+		}
+
+		if (email != "" && strings.EqualFold(strings.TrimSpace(headers["mail"]), email)) ||
+			(name != "" && strings.EqualFold(strings.TrimSpace(headers["name"]), name)) {
+			result = append(result, path)
+		}
+	}
+
+	sort.Strings(result)
+	sort.Strings(skipped)
+	return result, skipped, nil
+}
+
+// AnonymizeComment rewrites the given comment-file, replacing the name
+// of the submitter and removing their email-address, link, IP-address,
+// and user-agent.
+//
+// The body of the comment is left unchanged, so it still appears upon
+// the entry.
+func AnonymizeComment(path string) error {
+
+	reader := headerfile.New(path)
+
+	headers, err := reader.Headers()
+	if err != nil {
+		return err
+	}
+	body, err := reader.Body()
+	if err != nil {
+		return err
+	}
+
+	// Keep any other headers, in a stable order.
+	var keys []string
+	for key := range headers {
+		if !privateHeaders[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var out strings.Builder
+	out.WriteString("Name: " + AnonymousAuthor + "\n")
+	for _, key := range keys {
+		out.WriteString(key + ": " + headers[key] + "\n")
+	}
+	out.WriteString("\n")
+	out.WriteString(body)
+
+	return os.WriteFile(path, []byte(out.String()), 0644)
+}
@@ -0,0 +1,96 @@
+package ephemeris
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeComments creates the given comment-files in a temporary directory.
+func writeComments(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("failed to write %s: %s", name, err.Error())
+		}
+	}
+	return dir
+}
+
+// Test finding comments by email-address, and name.
+func TestFindCommentsBy(t *testing.T) {
+
+	dir := writeComments(t, map[string]string{
+		"entry.html.1": "Name: Steve\nMail: steve@example.com\n\nOne\n",
+		"entry.html.2": "Name: Bob\nMail: bob@example.com\n\nTwo\n",
+		"other.html.3": "Name: steve \nMail: Steve@Example.com\n\nThree\n",
+		"other.html.4": "Name: Stephen\nMail: stephen@example.com\n\nFour\n",
+		"other.html.5": "Bogus header\nMail: steve@example.com\n\nFive\n",
+	})
+
+	type TestCase struct {
+		email    string
+		name     string
+		expected []string
+	}
+
+	tests := []TestCase{
+		{"steve@example.com", "", []string{"entry.html.1", "other.html.3"}},
+		{"", "Steve", []string{"entry.html.1", "other.html.3"}},
+		{"bob@example.com", "Stephen", []string{"entry.html.2", "other.html.4"}},
+		{"nobody@example.com", "", nil},
+		{"", "", nil},
+	}
+
+	for _, tst := range tests {
+		found, skipped, err := FindCommentsBy(dir, tst.email, tst.name)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err.Error())
+		}
+
+		// Unreadable comments are skipped, rather than failing.
+		if len(skipped) != 1 || filepath.Base(skipped[0]) != "other.html.5" {
+			t.Errorf("unexpected skipped files %v", skipped)
+		}
+
+		var names []string
+		for _, f := range found {
+			names = append(names, filepath.Base(f))
+		}
+		if !reflect.DeepEqual(names, tst.expected) {
+			t.Errorf("finding '%s' '%s' gave %v not %v", tst.email, tst.name, names, tst.expected)
+		}
+	}
+}
+
+// Test anonymising a comment.
+func TestAnonymizeComment(t *testing.T) {
+
+	dir := writeComments(t, map[string]string{
+		"entry.html.1": "Name: Steve\nMail: steve@example.com\nLink: https://example.com/\nIP-Address: 1.2.3.4\nUser-Agent: test\nNotify: yes\nType: webmention\n\nHello, world\n",
+	})
+	path := filepath.Join(dir, "entry.html.1")
+
+	err := AnonymizeComment(path)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	data, _ := os.ReadFile(path)
+	for _, private := range []string{"Steve", "steve@example.com", "https://example.com/", "1.2.3.4", "test", "Notify"} {
+		if strings.Contains(string(data), private) {
+			t.Errorf("anonymised comment contains '%s': %s", private, data)
+		}
+	}
+
+	c, err := NewBlogComment(path)
+	if err != nil {
+		t.Fatalf("failed to read anonymised comment %s", err.Error())
+	}
+	if c.Author != AnonymousAuthor || c.Type != WebmentionType || !strings.Contains(c.Body, "Hello, world") {
+		t.Errorf("unexpected anonymised comment %v", c)
+	}
+}
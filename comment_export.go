@@ -0,0 +1,665 @@
+package ephemeris
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/headerfile"
+)
+
+// The formats in which comments may be exported, and imported.
+const (
+	// FormatJSON is our own JSON format, see CommentExport.
+	FormatJSON = "json"
+
+	// FormatWXR is the WordPress eXtended RSS format.
+	FormatWXR = "wxr"
+
+	// FormatDisqus is the Disqus XML export format.
+	FormatDisqus = "disqus"
+)
+
+// CommentExportVersion is the version of the JSON schema we produce.
+const CommentExportVersion = 1
+
+// CommentExport holds all the comments from a site, grouped into one
+// thread per entry.
+//
+// This is the JSON schema used by FormatJSON.
+type CommentExport struct {
+	// Version is the version of the schema, currently always 1.
+	Version int `json:"version"`
+
+	// Site is the URL-prefix of the blog the comments were exported from.
+	Site string `json:"site"`
+
+	// Threads holds the comments upon each entry.
+	Threads []CommentThread `json:"threads"`
+}
+
+// CommentThread holds the comments upon a single entry.
+type CommentThread struct {
+	// Link is the absolute URL of the entry.
+	Link string `json:"link"`
+
+	// Title is the title of the entry, if known.
+	Title string `json:"title,omitempty"`
+
+	// Comments holds the comments, oldest first.
+	Comments []ExportedComment `json:"comments"`
+}
+
+// ExportedComment holds a single exported comment.
+type ExportedComment struct {
+	// Author is the name of the comment-submitter.
+	Author string `json:"author"`
+
+	// Email is the email address of the comment-submitter.
+	Email string `json:"email,omitempty"`
+
+	// Link is the URL submitted with the comment.
+	Link string `json:"link,omitempty"`
+
+	// IP is the address the comment was submitted from.
+	IP string `json:"ip,omitempty"`
+
+	// UserAgent is the user-agent the comment was submitted with.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// Type is empty for regular comments, or the kind of mention
+	// for others, such as "webmention".
+	Type string `json:"type,omitempty"`
+
+	// Notify is true if the comment-submitter asked to be notified
+	// of later comments upon the same entry.
+	Notify bool `json:"notify,omitempty"`
+
+	// Date is when the comment was submitted.
+	Date time.Time `json:"date"`
+
+	// Body is the body of the comment, as it was stored.
+	Body string `json:"body"`
+}
+
+// ImportResult describes the outcome of importing comments.
+type ImportResult struct {
+	// Imported is the number of comments written.
+	Imported int
+
+	// Duplicates is the number of comments which were already present.
+	Duplicates int
+
+	// Unmatched holds the links of threads which didn't match an entry.
+	Unmatched []string
+}
+
+// commentDate returns the date of a comment, which is taken from the
+// suffix of the comment-file.
+//
+// Our comment files will be "$title.html.$ctime", so the suffix will be
+// ".$ctime", containing seconds past the unix epoch.
+func commentDate(path string) (time.Time, error) {
+	suffix := strings.TrimPrefix(filepath.Ext(path), ".")
+
+	i, err := strconv.ParseInt(suffix, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(i, 0), nil
+}
+
+// entryName returns the name of the file an entry is written to, which
+// is the lower-cased link without the prefix.
+func (e *Ephemeris) entryName(entry BlogEntry) string {
+	return strings.ToLower(strings.TrimPrefix(entry.Link, e.Prefix))
+}
+
+// ExportComments collects all the comments from the site.
+//
+// Comments are exported exactly as they were stored, including those
+// which don't match any current entry.
+func (e *Ephemeris) ExportComments() (CommentExport, error) {
+
+	export := CommentExport{Version: CommentExportVersion, Site: e.Prefix}
+
+	// Titles of the entries, by name.
+	titles := make(map[string]string)
+	for _, entry := range e.BlogEntries {
+		titles[e.entryName(entry)] = entry.Title
+	}
+
+	// Comments, by the name of the entry they're upon.
+	threads := make(map[string][]ExportedComment)
+
+	for _, file := range e.CommentFiles {
+
+		reader := headerfile.New(file)
+		headers, err := reader.Headers()
+		if err != nil {
+			return export, err
+		}
+		body, _ := reader.Body()
+
+		date, err := commentDate(file)
+		if err != nil {
+			return export, fmt.Errorf("failed to parse %s - %s", file, err.Error())
+		}
+
+		base := filepath.Base(file)
+		name := strings.TrimSuffix(base, filepath.Ext(base))
+
+		threads[name] = append(threads[name], ExportedComment{
+			Author:    headers["name"],
+			Email:     headers["mail"],
+			Link:      headers["link"],
+			IP:        headers["ip-address"],
+			UserAgent: headers["user-agent"],
+			Type:      headers["type"],
+			Notify:    strings.ToLower(headers["notify"]) == "yes",
+			Date:      date.UTC(),
+			Body:      body,
+		})
+	}
+
+	// Sort the threads by name, and the comments by date, so that
+	// the output is stable.
+	var names []string
+	for name := range threads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		comments := threads[name]
+		sort.SliceStable(comments, func(i, j int) bool {
+			return comments[i].Date.Before(comments[j].Date)
+		})
+
+		export.Threads = append(export.Threads, CommentThread{
+			Link:     e.Prefix + name,
+			Title:    titles[name],
+			Comments: comments,
+		})
+	}
+
+	return export, nil
+}
+
+// ImportComments writes the comments from the given export into the
+// comment-directory, as comment-files upon the matching entries.
+//
+// Threads are matched to entries by the last component of their link,
+// or failing that by their title.  Comments which are already present
+// are skipped, so importing the same file twice is harmless.
+func (e *Ephemeris) ImportComments(export CommentExport, commentPath string) (ImportResult, error) {
+
+	var result ImportResult
+
+	// Entries, by name.
+	names := make(map[string]bool)
+	for _, entry := range e.BlogEntries {
+		names[e.entryName(entry)] = true
+	}
+
+	for _, thread := range export.Threads {
+
+		name := ""
+
+		// Match by link
+		if u, err := url.Parse(thread.Link); err == nil {
+			base := strings.ToLower(path.Base(u.Path))
+			if names[base] {
+				name = base
+			}
+		}
+
+		// Match by title
+		if name == "" && thread.Title != "" {
+			link := strings.ToLower(linkRegexp.ReplaceAllString(thread.Title, "_") + ".html")
+			if names[link] {
+				name = link
+			}
+		}
+
+		if name == "" {
+			result.Unmatched = append(result.Unmatched, thread.Link)
+			continue
+		}
+
+		for _, comment := range thread.Comments {
+			written, err := writeImportedComment(commentPath, name, comment)
+			if err != nil {
+				return result, err
+			}
+			if written {
+				result.Imported++
+			} else {
+				result.Duplicates++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// writeImportedComment writes a single comment to a comment-file, unless
+// the same comment has already been imported.
+//
+// Comments are written to the first free name at, or after, the time of
+// the comment, so each of the comments already there is checked.  Links
+// are checked in the same way as submitted links, so only http and https
+// links are imported.
+func writeImportedComment(dir string, name string, comment ExportedComment) (bool, error) {
+
+	var file string
+	for i := comment.Date.Unix(); ; i++ {
+		file = filepath.Join(dir, fmt.Sprintf("%s.%d", name, i))
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			break
+		}
+		if sameComment(file, comment) {
+			return false, nil
+		}
+	}
+
+	// Newlines would break our headers.
+	clean := strings.NewReplacer("\r", "", "\n", " ")
+
+	var out strings.Builder
+	header := func(key string, val string) {
+		if val != "" {
+			out.WriteString(key + ": " + strings.TrimSpace(clean.Replace(val)) + "\n")
+		}
+	}
+	header("Name", comment.Author)
+	header("Mail", comment.Email)
+	header("Link", commentLink(comment.Link))
+	header("User-Agent", comment.UserAgent)
+	header("IP-Address", comment.IP)
+	header("Type", comment.Type)
+	if comment.Notify {
+		header("Notify", "yes")
+	}
+	out.WriteString("\n")
+	out.WriteString(comment.Body)
+
+	return true, os.WriteFile(file, []byte(out.String()), 0644)
+}
+
+// sameComment returns true if the given comment-file holds the given
+// comment, from the same author with the same body.
+func sameComment(file string, comment ExportedComment) bool {
+
+	reader := headerfile.New(file)
+	headers, err := reader.Headers()
+	if err != nil || headers["name"] != strings.TrimSpace(comment.Author) {
+		return false
+	}
+
+	body, err := reader.Body()
+	return err == nil && strings.TrimSpace(body) == strings.TrimSpace(comment.Body)
+}
+
+// WriteCommentExport writes the export to the given writer, in the
+// specified format.
+func WriteCommentExport(w io.Writer, export CommentExport, format string) error {
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(export)
+
+	case FormatWXR:
+		return writeXML(w, toWXR(export))
+
+	case FormatDisqus:
+		return writeXML(w, toDisqus(export))
+	}
+
+	return fmt.Errorf("unknown comment-format %s", format)
+}
+
+// ReadCommentExport reads an export, in the specified format, from the
+// given reader.
+func ReadCommentExport(r io.Reader, format string) (CommentExport, error) {
+
+	switch format {
+	case FormatJSON:
+		var export CommentExport
+		err := json.NewDecoder(r).Decode(&export)
+		if err == nil && export.Version != CommentExportVersion {
+			err = fmt.Errorf("unsupported export version %d", export.Version)
+		}
+		return export, err
+
+	case FormatWXR:
+		var in wxrIn
+		err := xml.NewDecoder(r).Decode(&in)
+		if err != nil {
+			return CommentExport{}, err
+		}
+		return fromWXR(in)
+
+	case FormatDisqus:
+		var in disqusIn
+		err := xml.NewDecoder(r).Decode(&in)
+		if err != nil {
+			return CommentExport{}, err
+		}
+		return fromDisqus(in)
+	}
+
+	return CommentExport{}, fmt.Errorf("unknown comment-format %s", format)
+}
+
+// writeXML writes the given object as an indented XML document.
+func writeXML(w io.Writer, v interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+//
+// WordPress eXtended RSS.
+//
+// The element-names we write include their namespace prefix, because
+// the XML encoder doesn't support prefixes, but when reading we match
+// on the local names, so that all versions of the format are accepted.
+//
+
+// wxrDate is the format of dates in WXR files.
+const wxrDate = "2006-01-02 15:04:05"
+
+type wxrOut struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	Content   string     `xml:"xmlns:content,attr"`
+	DC        string     `xml:"xmlns:dc,attr"`
+	WP        string     `xml:"xmlns:wp,attr"`
+	Link      string     `xml:"channel>link"`
+	WXR       string     `xml:"channel>wp:wxr_version"`
+	BaseURL   string     `xml:"channel>wp:base_blog_url"`
+	Generator string     `xml:"channel>generator"`
+	Items     []wxrItemO `xml:"channel>item"`
+}
+
+type wxrItemO struct {
+	Title    string        `xml:"title"`
+	Link     string        `xml:"link"`
+	Type     string        `xml:"wp:post_type"`
+	Status   string        `xml:"wp:status"`
+	Comments []wxrCommentO `xml:"wp:comment"`
+}
+
+type wxrCommentO struct {
+	ID       int    `xml:"wp:comment_id"`
+	Author   string `xml:"wp:comment_author"`
+	Email    string `xml:"wp:comment_author_email"`
+	URL      string `xml:"wp:comment_author_url"`
+	IP       string `xml:"wp:comment_author_IP"`
+	Date     string `xml:"wp:comment_date"`
+	DateGMT  string `xml:"wp:comment_date_gmt"`
+	Content  string `xml:"wp:comment_content"`
+	Approved string `xml:"wp:comment_approved"`
+	Agent    string `xml:"wp:comment_agent"`
+	Type     string `xml:"wp:comment_type"`
+	Parent   int    `xml:"wp:comment_parent"`
+	UserID   int    `xml:"wp:comment_user_id"`
+}
+
+type wxrIn struct {
+	Items []struct {
+		Title    string `xml:"title"`
+		Link     string `xml:"link"`
+		Comments []struct {
+			Author   string `xml:"comment_author"`
+			Email    string `xml:"comment_author_email"`
+			URL      string `xml:"comment_author_url"`
+			IP       string `xml:"comment_author_IP"`
+			Date     string `xml:"comment_date"`
+			DateGMT  string `xml:"comment_date_gmt"`
+			Content  string `xml:"comment_content"`
+			Approved string `xml:"comment_approved"`
+			Agent    string `xml:"comment_agent"`
+			Type     string `xml:"comment_type"`
+		} `xml:"comment"`
+	} `xml:"channel>item"`
+}
+
+// toWXR converts an export to WXR.
+func toWXR(export CommentExport) wxrOut {
+
+	out := wxrOut{
+		Version:   "2.0",
+		Content:   "http://purl.org/rss/1.0/modules/content/",
+		DC:        "http://purl.org/dc/elements/1.1/",
+		WP:        "http://wordpress.org/export/1.2/",
+		Link:      export.Site,
+		WXR:       "1.2",
+		BaseURL:   export.Site,
+		Generator: "https://github.com/skx/ephemeris",
+	}
+
+	id := 0
+	for _, thread := range export.Threads {
+		item := wxrItemO{Title: thread.Title, Link: thread.Link, Type: "post", Status: "publish"}
+
+		for _, c := range thread.Comments {
+			id++
+			item.Comments = append(item.Comments, wxrCommentO{
+				ID:       id,
+				Author:   c.Author,
+				Email:    c.Email,
+				URL:      c.Link,
+				IP:       c.IP,
+				Date:     c.Date.UTC().Format(wxrDate),
+				DateGMT:  c.Date.UTC().Format(wxrDate),
+				Content:  c.Body,
+				Approved: "1",
+				Agent:    c.UserAgent,
+				Type:     c.Type,
+			})
+		}
+		out.Items = append(out.Items, item)
+	}
+	return out
+}
+
+// fromWXR converts WXR to an export.
+//
+// Only approved comments are imported, spam and pending comments are
+// ignored.
+func fromWXR(in wxrIn) (CommentExport, error) {
+
+	export := CommentExport{Version: CommentExportVersion}
+
+	for _, item := range in.Items {
+		thread := CommentThread{Title: strings.TrimSpace(item.Title), Link: strings.TrimSpace(item.Link)}
+
+		for _, c := range item.Comments {
+			if strings.TrimSpace(c.Approved) != "1" {
+				continue
+			}
+
+			date, err := time.Parse(wxrDate, strings.TrimSpace(c.DateGMT))
+			if err != nil || date.Year() < 1970 {
+				date, err = time.Parse(wxrDate, strings.TrimSpace(c.Date))
+				if err != nil {
+					return export, fmt.Errorf("comment by %s on %s has an invalid date: %s", strings.TrimSpace(c.Author), thread.Link, err.Error())
+				}
+			}
+
+			thread.Comments = append(thread.Comments, ExportedComment{
+				Author:    strings.TrimSpace(c.Author),
+				Email:     strings.TrimSpace(c.Email),
+				Link:      strings.TrimSpace(c.URL),
+				IP:        strings.TrimSpace(c.IP),
+				UserAgent: strings.TrimSpace(c.Agent),
+				Type:      strings.TrimSpace(c.Type),
+				Date:      date,
+				Body:      c.Content,
+			})
+		}
+
+		if len(thread.Comments) > 0 {
+			export.Threads = append(export.Threads, thread)
+		}
+	}
+	return export, nil
+}
+
+//
+// Disqus.
+//
+// Posts are associated with their thread via the "dsq:id" attribute.
+//
+
+type disqusOut struct {
+	XMLName xml.Name        `xml:"disqus"`
+	NS      string          `xml:"xmlns,attr"`
+	DSQ     string          `xml:"xmlns:dsq,attr"`
+	Threads []disqusThreadO `xml:"thread"`
+	Posts   []disqusPostO   `xml:"post"`
+}
+
+type disqusThreadO struct {
+	DsqID string `xml:"dsq:id,attr"`
+	ID    string `xml:"id"`
+	Link  string `xml:"link"`
+	Title string `xml:"title"`
+}
+
+type disqusPostO struct {
+	DsqID     string        `xml:"dsq:id,attr"`
+	Message   string        `xml:"message"`
+	CreatedAt string        `xml:"createdAt"`
+	IsDeleted bool          `xml:"isDeleted"`
+	IsSpam    bool          `xml:"isSpam"`
+	Email     string        `xml:"author>email"`
+	Name      string        `xml:"author>name"`
+	IP        string        `xml:"ipAddress"`
+	Thread    disqusThreadR `xml:"thread"`
+}
+
+type disqusThreadR struct {
+	DsqID string `xml:"dsq:id,attr"`
+}
+
+type disqusIn struct {
+	Threads []struct {
+		DsqID string `xml:"id,attr"`
+		Link  string `xml:"link"`
+		Title string `xml:"title"`
+	} `xml:"thread"`
+	Posts []struct {
+		Message   string `xml:"message"`
+		CreatedAt string `xml:"createdAt"`
+		IsDeleted bool   `xml:"isDeleted"`
+		IsSpam    bool   `xml:"isSpam"`
+		Email     string `xml:"author>email"`
+		Name      string `xml:"author>name"`
+		IP        string `xml:"ipAddress"`
+		Thread    struct {
+			DsqID string `xml:"id,attr"`
+		} `xml:"thread"`
+	} `xml:"post"`
+}
+
+// toDisqus converts an export to the Disqus format.
+func toDisqus(export CommentExport) disqusOut {
+
+	out := disqusOut{
+		NS:  "http://disqus.com",
+		DSQ: "http://disqus.com/disqus-internals",
+	}
+
+	id := 0
+	for i, thread := range export.Threads {
+		tid := strconv.Itoa(i + 1)
+		out.Threads = append(out.Threads, disqusThreadO{DsqID: tid, ID: thread.Link, Link: thread.Link, Title: thread.Title})
+
+		for _, c := range thread.Comments {
+			id++
+			out.Posts = append(out.Posts, disqusPostO{
+				DsqID:     strconv.Itoa(id),
+				Message:   c.Body,
+				CreatedAt: c.Date.UTC().Format(time.RFC3339),
+				Email:     c.Email,
+				Name:      c.Author,
+				IP:        c.IP,
+				Thread:    disqusThreadR{DsqID: tid},
+			})
+		}
+	}
+	return out
+}
+
+// fromDisqus converts the Disqus format to an export.
+//
+// Deleted and spam posts are ignored.
+func fromDisqus(in disqusIn) (CommentExport, error) {
+
+	export := CommentExport{Version: CommentExportVersion}
+
+	// Threads by their ID, and the order we found them in.
+	threads := make(map[string]*CommentThread)
+	var order []string
+
+	for _, t := range in.Threads {
+		threads[t.DsqID] = &CommentThread{Link: strings.TrimSpace(t.Link), Title: strings.TrimSpace(t.Title)}
+		order = append(order, t.DsqID)
+	}
+
+	for _, p := range in.Posts {
+		if p.IsDeleted || p.IsSpam {
+			continue
+		}
+
+		thread, ok := threads[p.Thread.DsqID]
+		if !ok {
+			return export, fmt.Errorf("post references unknown thread %s", p.Thread.DsqID)
+		}
+
+		date, err := time.Parse(time.RFC3339, strings.TrimSpace(p.CreatedAt))
+		if err != nil {
+			return export, err
+		}
+
+		thread.Comments = append(thread.Comments, ExportedComment{
+			Author: strings.TrimSpace(p.Name),
+			Email:  strings.TrimSpace(p.Email),
+			IP:     strings.TrimSpace(p.IP),
+			Date:   date,
+			Body:   p.Message,
+		})
+	}
+
+	for _, id := range order {
+		if len(threads[id].Comments) > 0 {
+			export.Threads = append(export.Threads, *threads[id])
+		}
+	}
+	return export, nil
+}
@@ -0,0 +1,289 @@
+package ephemeris
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that exports of each format can be read back, and imported to
+// recreate the original comment-files.
+func TestCommentExportRoundTrip(t *testing.T) {
+
+	site, err := New("_demo/data", "_demo/comments", "http://localhost:8000/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	export, err := site.ExportComments()
+	if err != nil {
+		t.Fatalf("error exporting comments: %s", err.Error())
+	}
+	if len(export.Threads) != 1 || len(export.Threads[0].Comments) != 2 {
+		t.Fatalf("unexpected export: %v", export)
+	}
+	if export.Threads[0].Title != "This post has some comments" {
+		t.Errorf("export has the wrong title: %s", export.Threads[0].Title)
+	}
+	if export.Threads[0].Comments[0].IP != "1.2.3.4" {
+		t.Errorf("export lost the IP address")
+	}
+
+	for _, format := range []string{FormatJSON, FormatWXR, FormatDisqus} {
+
+		var buf bytes.Buffer
+		err = WriteCommentExport(&buf, export, format)
+		if err != nil {
+			t.Fatalf("error writing %s: %s", format, err.Error())
+		}
+
+		back, err := ReadCommentExport(&buf, format)
+		if err != nil {
+			t.Fatalf("error reading %s: %s", format, err.Error())
+		}
+
+		dir := t.TempDir()
+		result, err := site.ImportComments(back, dir)
+		if err != nil {
+			t.Fatalf("error importing %s: %s", format, err.Error())
+		}
+		if result.Imported != 2 || result.Duplicates != 0 || len(result.Unmatched) != 0 {
+			t.Errorf("unexpected import-result from %s: %v", format, result)
+		}
+
+		// The same comments should be present, with the same
+		// names, as the original.
+		orig, _ := os.ReadDir("_demo/comments")
+		files, _ := os.ReadDir(dir)
+		if len(files) != len(orig) {
+			t.Fatalf("wrong number of files imported from %s", format)
+		}
+		for i := range files {
+			if files[i].Name() != orig[i].Name() {
+				t.Errorf("imported file %s != %s", files[i].Name(), orig[i].Name())
+			}
+
+			a, _ := NewBlogComment(filepath.Join("_demo/comments", orig[i].Name()))
+			b, _ := NewBlogComment(filepath.Join(dir, files[i].Name()))
+
+			// Disqus has nowhere to store the link, or the
+			// user-agent.
+			if format == FormatDisqus {
+				a.Link = ""
+				a.UserAgent = ""
+				delete(a.Headers, "link")
+				delete(a.Headers, "user-agent")
+			}
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("imported comment from %s differs: %v != %v", format, a, b)
+			}
+		}
+
+		// Importing again is harmless.
+		result, err = site.ImportComments(back, dir)
+		if err != nil {
+			t.Fatalf("error re-importing %s: %s", format, err.Error())
+		}
+		if result.Imported != 0 || result.Duplicates != 2 {
+			t.Errorf("unexpected re-import result from %s: %v", format, result)
+		}
+	}
+}
+
+// Test subscriptions survive a round-trip, and bogus links are dropped.
+func TestImportNotifyAndLink(t *testing.T) {
+
+	site, err := New("_demo/data", "", "http://localhost:8000/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	export := CommentExport{Version: CommentExportVersion, Threads: []CommentThread{{
+		Link: "http://localhost:8000/this_post_has_some_comments.html",
+		Comments: []ExportedComment{
+			{Author: "Steve", Link: "javascript:alert(1)", Notify: true, Date: time.Unix(1000, 0), Body: "One"},
+			{Author: "Bob", Link: "example.com", Date: time.Unix(2000, 0), Body: "Two"},
+		},
+	}}}
+
+	var buf bytes.Buffer
+	err = WriteCommentExport(&buf, export, FormatJSON)
+	if err != nil {
+		t.Fatalf("error writing: %s", err.Error())
+	}
+	back, err := ReadCommentExport(&buf, FormatJSON)
+	if err != nil {
+		t.Fatalf("error reading: %s", err.Error())
+	}
+
+	dir := t.TempDir()
+	_, err = site.ImportComments(back, dir)
+	if err != nil {
+		t.Fatalf("error importing: %s", err.Error())
+	}
+
+	one, _ := os.ReadFile(filepath.Join(dir, "this_post_has_some_comments.html.1000"))
+	if !strings.Contains(string(one), "Notify: yes") || strings.Contains(string(one), "Link:") {
+		t.Errorf("unexpected imported comment %s", one)
+	}
+	two, _ := os.ReadFile(filepath.Join(dir, "this_post_has_some_comments.html.2000"))
+	if strings.Contains(string(two), "Notify:") || !strings.Contains(string(two), "Link: http://example.com") {
+		t.Errorf("unexpected imported comment %s", two)
+	}
+
+	// And the subscription is exported again.
+	imported, err := New("_demo/data", dir, "http://localhost:8000/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+	again, err := imported.ExportComments()
+	if err != nil {
+		t.Fatalf("error exporting comments: %s", err.Error())
+	}
+	if len(again.Threads) != 1 || !again.Threads[0].Comments[0].Notify || again.Threads[0].Comments[1].Notify {
+		t.Errorf("subscriptions weren't exported: %v", again)
+	}
+}
+
+// Test importing from a WordPress export, matching by title.
+func TestImportWXR(t *testing.T) {
+
+	site, err := New("_demo/data", "", "http://localhost:8000/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	f, err := os.Open("_test/comment_export/wordpress.xml")
+	if err != nil {
+		t.Fatalf("error opening export: %s", err.Error())
+	}
+	defer f.Close()
+
+	export, err := ReadCommentExport(f, FormatWXR)
+	if err != nil {
+		t.Fatalf("error reading export: %s", err.Error())
+	}
+
+	dir := t.TempDir()
+	result, err := site.ImportComments(export, dir)
+	if err != nil {
+		t.Fatalf("error importing: %s", err.Error())
+	}
+
+	// One comment imported, the spam ignored, and one unmatched post
+	if result.Imported != 1 || len(result.Unmatched) != 1 {
+		t.Fatalf("unexpected import-result: %v", result)
+	}
+
+	c, err := NewBlogComment(filepath.Join(dir, "this_post_has_some_comments.html.1570957200"))
+	if err != nil {
+		t.Fatalf("error reading imported comment: %s", err.Error())
+	}
+	if c.Author != "Alice" || c.Link != "https://alice.example.com/" || !strings.Contains(c.Body, "I liked this.") {
+		t.Errorf("unexpected imported comment: %v", c)
+	}
+}
+
+// Test importing from a Disqus export, matching by link.
+func TestImportDisqus(t *testing.T) {
+
+	site, err := New("_demo/data", "", "http://localhost:8000/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	f, err := os.Open("_test/comment_export/disqus.xml")
+	if err != nil {
+		t.Fatalf("error opening export: %s", err.Error())
+	}
+	defer f.Close()
+
+	export, err := ReadCommentExport(f, FormatDisqus)
+	if err != nil {
+		t.Fatalf("error reading export: %s", err.Error())
+	}
+
+	dir := t.TempDir()
+	result, err := site.ImportComments(export, dir)
+	if err != nil {
+		t.Fatalf("error importing: %s", err.Error())
+	}
+	if result.Imported != 1 || len(result.Unmatched) != 0 {
+		t.Fatalf("unexpected import-result: %v", result)
+	}
+
+	c, err := NewBlogComment(filepath.Join(dir, "this_is_my_test_post.html.1570795200"))
+	if err != nil {
+		t.Fatalf("error reading imported comment: %s", err.Error())
+	}
+	if c.Author != "Carol" || !strings.Contains(c.Body, "Great post!") {
+		t.Errorf("unexpected imported comment: %v", c)
+	}
+}
+
+// Test unknown formats are errors.
+func TestCommentExportFormat(t *testing.T) {
+
+	var buf bytes.Buffer
+	err := WriteCommentExport(&buf, CommentExport{}, "bogus")
+	if err == nil {
+		t.Errorf("expected an error writing an unknown format")
+	}
+
+	_, err = ReadCommentExport(&buf, "bogus")
+	if err == nil {
+		t.Errorf("expected an error reading an unknown format")
+	}
+}
+
+// Test that re-importing comments made at the same second is harmless.
+func TestImportSameSecond(t *testing.T) {
+
+	site, err := New("_demo/data", "", "http://localhost:8000/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	date := time.Unix(1570957200, 0)
+	export := CommentExport{Version: CommentExportVersion, Threads: []CommentThread{{
+		Title: "This post has some comments",
+		Comments: []ExportedComment{
+			{Author: "Alice", Date: date, Body: "First"},
+			{Author: "Alice", Date: date, Body: "Second"},
+			{Author: "Bob", Date: date, Body: "Third"},
+		},
+	}}}
+
+	dir := t.TempDir()
+	for i, expected := range []int{3, 0} {
+		result, err := site.ImportComments(export, dir)
+		if err != nil {
+			t.Fatalf("error importing: %s", err.Error())
+		}
+		if result.Imported != expected || result.Duplicates != 3-expected {
+			t.Errorf("import %d had unexpected result: %v", i, result)
+		}
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 3 {
+		t.Errorf("unexpected comment-files %v", files)
+	}
+}
+
+// Test that WordPress comments without a valid date are rejected.
+func TestImportWXRInvalidDate(t *testing.T) {
+
+	in := `<rss><channel><item><title>Post</title>
+<wp:comment><wp:comment_author>Alice</wp:comment_author><wp:comment_date>never</wp:comment_date><wp:comment_approved>1</wp:comment_approved><wp:comment_content>Hello</wp:comment_content></wp:comment>
+</item></channel></rss>`
+
+	_, err := ReadCommentExport(strings.NewReader(in), FormatWXR)
+	if err == nil || !strings.Contains(err.Error(), "invalid date") {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
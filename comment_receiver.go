@@ -0,0 +1,254 @@
+package ephemeris
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CommentReceiver is a HTTP handler which accepts comments submitted via
+// the add-comment form, and writes them to the comment-directory.
+//
+// This is a replacement for the `comments.cgi` script, which also sends
+// notifications of new comments.
+type CommentReceiver struct {
+	// Prefix is the URL-prefix of the blog.
+	Prefix string
+
+	// CommentsPath is the directory into which comments are written.
+	CommentsPath string
+
+	// Notifier is used to send notifications of new comments, if
+	// it is non-nil.
+	Notifier *Notifier
+
+	// Moderator is used to hold comments until they're approved,
+	// if it is non-nil.
+	Moderator *Moderator
+
+	// Site returns the blog, and comments are only accepted upon
+	// those of its entries which accept comments.  It is called for
+	// each comment, so that it may return a blog which is reloaded
+	// as entries are published.
+	//
+	// If this is nil no comments are accepted.
+	Site func() (*Ephemeris, error)
+}
+
+// commentsOpen returns true if the entry with the given name, within the
+// site returned by load, accepts comments at the given time.
+func commentsOpen(load func() (*Ephemeris, error), name string, now time.Time) (bool, error) {
+
+	if load == nil {
+		return false, nil
+	}
+
+	site, err := load()
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range site.Entries() {
+		if site.entryName(entry) == name {
+			return CommentsOpen(entry, site.Newest(), site.Options.CommentDays, now), nil
+		}
+	}
+	return false, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (c *CommentReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Newlines would break our headers.
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	name := strings.TrimSpace(clean.Replace(r.FormValue("name")))
+	mail := strings.TrimSpace(clean.Replace(r.FormValue("mail")))
+	link := strings.ToLower(strings.TrimSpace(clean.Replace(r.FormValue("link"))))
+	id := strings.ToLower(strings.TrimSpace(clean.Replace(r.FormValue("id"))))
+	body := r.FormValue("body")
+
+	//
+	// If any fields are missing redirect back to the blog.
+	//
+	if name == "" || mail == "" || strings.TrimSpace(body) == "" || id == "" {
+		http.Redirect(w, r, c.Prefix+"#missing-field", http.StatusSeeOther)
+		return
+	}
+
+	//
+	// The hidden field should be empty, if not we've got a robot.
+	//
+	if r.FormValue("robot") != "" {
+		http.Redirect(w, r, c.Prefix+"#robot", http.StatusSeeOther)
+		return
+	}
+
+	//
+	// The ID is the link to the entry, we only want the last
+	// component, and it must be the name of one of our entries.
+	//
+	if i := strings.LastIndexAny(id, "/\\"); i >= 0 {
+		id = id[i+1:]
+	}
+	if !entryName.MatchString(id) {
+		http.Error(w, "Invalid entry", http.StatusBadRequest)
+		return
+	}
+
+	//
+	// The entry must exist, and accept comments.
+	//
+	open, err := commentsOpen(c.Site, id, time.Now())
+	if err != nil {
+		log.Printf("failed to load the site: %s", err.Error())
+		http.Error(w, "Failed to store comment", http.StatusInternalServerError)
+		return
+	}
+	if !open {
+		http.Error(w, "Comments are closed", http.StatusForbidden)
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "Name: %s\n", name)
+	fmt.Fprintf(&out, "Mail: %s\n", mail)
+	if link != "" {
+		fmt.Fprintf(&out, "Link: %s\n", link)
+	}
+	fmt.Fprintf(&out, "User-Agent: %s\n", clean.Replace(r.UserAgent()))
+	fmt.Fprintf(&out, "IP-Address: %s\n", ip)
+	fmt.Fprintf(&out, "\n%s\n", strings.ReplaceAll(body, "\r\n", "\n"))
+
+	// Comments awaiting moderation are written elsewhere.
+	dir := c.CommentsPath
+	if c.Moderator != nil {
+		dir = c.Moderator.PendingPath
+	}
+
+	file, err := writeCommentFile(dir, id, out.String())
+	if err != nil {
+		log.Printf("failed to write comment: %s", err.Error())
+		http.Error(w, "Failed to store comment", http.StatusInternalServerError)
+		return
+	}
+
+	//
+	// Send notifications, in the background.
+	//
+	if c.Notifier != nil {
+		comment := NewComment{
+			File:   file,
+			Entry:  c.Prefix + id,
+			Author: name,
+			Mail:   mail,
+			Link:   link,
+			IP:     ip,
+			Body:   body,
+		}
+		notifyComment(c.Notifier, c.Moderator, comment)
+
+		//
+		// Commenters who asked to be notified of later comments
+		// must confirm it, so that nobody can be subscribed by
+		// somebody else.
+		//
+		if r.FormValue("notify") != "" {
+			go func() {
+				err := c.Notifier.ConfirmSubscription(comment)
+				if err != nil {
+					log.Printf("failed to confirm subscription for %s: %s", file, err.Error())
+				}
+			}()
+		}
+	}
+
+	thanks := "Your comment will be included the next time this blog is rebuilt."
+	if c.Moderator != nil {
+		thanks = "Your comment will be included once it has been approved."
+	}
+	if r.FormValue("notify") != "" && c.Notifier != nil && c.Notifier.Moderator != nil {
+		thanks += "  You'll be sent an email to confirm that you want to be told about later comments."
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<html>
+ <head>
+  <title>Thanks For Your Comment</title>
+ </head>
+ <body>
+  <h2>Thanks!</h2>
+  <p>%s</p>
+  <p><a href="%s">Return to blog</a>.</p>
+ </body>
+</html>
+`, thanks, html.EscapeString(c.Prefix+id))
+}
+
+// writeCommentFile writes a new comment-file, for the named entry, into
+// the given directory and returns its path.
+//
+// Comment-files are named "${link}.${ctime}", so we pick the first free
+// name from the current time.
+func writeCommentFile(dir string, name string, content string) (string, error) {
+
+	now := time.Now().Unix()
+	for {
+		path := filepath.Join(dir, fmt.Sprintf("%s.%d", name, now))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			now++
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		_, err = f.WriteString(content)
+		if err != nil {
+			f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
+
+// notifyComment sends the notifications for a new comment, in the
+// background.
+//
+// If the comment is awaiting moderation, because the moderator is
+// non-nil, then the owner receives links to moderate it, and the
+// subscribers aren't notified until it is approved.
+func notifyComment(notifier *Notifier, moderator *Moderator, comment NewComment) {
+
+	notify := notifier.Notify
+	if moderator != nil {
+		now := time.Now()
+		comment.Approve = moderator.Link(ActionApprove, filepath.Base(comment.File), now)
+		comment.Reject = moderator.Link(ActionDelete, filepath.Base(comment.File), now)
+		notify = notifier.NotifyOwner
+	}
+
+	go func() {
+		err := notify(comment)
+		if err != nil {
+			log.Printf("failed to send notifications for %s: %s", comment.File, err.Error())
+		}
+	}()
+}
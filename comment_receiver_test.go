@@ -0,0 +1,190 @@
+package ephemeris
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postComment submits the given form to the receiver.
+func postComment(c *CommentReceiver, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/comment", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "test-agent")
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+	return rec
+}
+
+// testCommentSite returns a function returning a site with two entries,
+// the newest of which accepts comments.
+func testCommentSite() func() (*Ephemeris, error) {
+	site := &Ephemeris{Prefix: "https://example.com/"}
+	site.BlogEntries = []BlogEntry{
+		{Title: "My First Post", Path: "first.txt", Link: "https://example.com/My_First_Post.html", Date: time.Now()},
+		{Title: "Old Post", Path: "old.txt", Link: "https://example.com/old_post.html", Date: time.Now().AddDate(-1, 0, 0)},
+	}
+	return func() (*Ephemeris, error) {
+		return site, nil
+	}
+}
+
+// Test that comments are accepted, and written to disk.
+func TestCommentReceiver(t *testing.T) {
+
+	dir := t.TempDir()
+	c := &CommentReceiver{Prefix: "https://example.com/", CommentsPath: dir, Site: testCommentSite()}
+
+	rec := postComment(c, url.Values{
+		"name":   {"Steve"},
+		"mail":   {"steve@example.com"},
+		"link":   {"Example.NET"},
+		"id":     {"https://example.com/my_first_post.html"},
+		"body":   {"Hello [world](https://example.org/)"},
+		"notify": {"yes"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || !strings.HasPrefix(files[0].Name(), "my_first_post.html.") {
+		t.Fatalf("unexpected comment-files: %v", files)
+	}
+
+	path := filepath.Join(dir, files[0].Name())
+	data, _ := os.ReadFile(path)
+	for _, expected := range []string{"Name: Steve", "Mail: steve@example.com", "Link: example.net", "User-Agent: test-agent", "IP-Address: 192.0.2.1"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("comment-file didn't contain '%s': %s", expected, data)
+		}
+	}
+
+	// Subscriptions aren't recorded until they're confirmed.
+	if strings.Contains(string(data), "Notify:") {
+		t.Errorf("comment-file recorded an unconfirmed subscription: %s", data)
+	}
+
+	b, err := NewBlogComment(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading comment %s", err.Error())
+	}
+	if b.Author != "Steve" || !strings.Contains(b.Body, `<a href="https://example.org/" rel="nofollow ugc">world</a>`) {
+		t.Errorf("unexpected comment: %v", b)
+	}
+}
+
+// Test that bogus submissions are rejected.
+func TestCommentReceiverBogus(t *testing.T) {
+
+	dir := t.TempDir()
+	c := &CommentReceiver{Prefix: "https://example.com/", CommentsPath: dir, Site: testCommentSite()}
+
+	valid := func() url.Values {
+		return url.Values{
+			"name": {"Steve"},
+			"mail": {"steve@example.com"},
+			"id":   {"https://example.com/my_first_post.html"},
+			"body": {"Hello"},
+		}
+	}
+
+	// Missing fields
+	for _, field := range []string{"name", "mail", "id", "body"} {
+		form := valid()
+		form.Del(field)
+		rec := postComment(c, form)
+		if rec.Code != http.StatusSeeOther || !strings.HasSuffix(rec.Header().Get("Location"), "#missing-field") {
+			t.Errorf("expected a redirect with missing %s, got %d", field, rec.Code)
+		}
+	}
+
+	// Robots
+	form := valid()
+	form.Set("robot", "beep")
+	rec := postComment(c, form)
+	if rec.Code != http.StatusSeeOther || !strings.HasSuffix(rec.Header().Get("Location"), "#robot") {
+		t.Errorf("expected a redirect for a robot, got %d", rec.Code)
+	}
+
+	// Bogus entries
+	form = valid()
+	form.Set("id", "../../etc/passwd")
+	rec = postComment(c, form)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a bogus entry to be rejected, got %d", rec.Code)
+	}
+
+	// Entries which don't exist, or don't accept comments
+	for _, id := range []string{"missing.html", "https://example.com/old_post.html"} {
+		form = valid()
+		form.Set("id", id)
+		rec = postComment(c, form)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected a comment on %s to be rejected, got %d", id, rec.Code)
+		}
+	}
+
+	// Without a site no comments are accepted
+	rec = postComment(&CommentReceiver{Prefix: c.Prefix, CommentsPath: dir}, valid())
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected a comment to be rejected without a site, got %d", rec.Code)
+	}
+
+	// Only POST
+	req := httptest.NewRequest("GET", "/comment", nil)
+	rr := httptest.NewRecorder()
+	c.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected, got %d", rr.Code)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("bogus submissions were written: %v", files)
+	}
+}
+
+// Test that notifications are sent for new comments.
+func TestCommentReceiverNotify(t *testing.T) {
+
+	server := newFakeSMTP(t)
+	defer server.listener.Close()
+
+	c := &CommentReceiver{
+		Prefix:       "https://example.com/",
+		CommentsPath: t.TempDir(),
+		Site:         testCommentSite(),
+		Notifier: &Notifier{
+			Host:  "127.0.0.1",
+			Port:  server.port(),
+			From:  "blog@example.com",
+			Owner: "owner@example.com",
+		},
+	}
+
+	rec := postComment(c, url.Values{
+		"name": {"Steve"},
+		"mail": {"steve@example.com"},
+		"id":   {"my_first_post.html"},
+		"body": {"Hello"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+
+	// Notifications are sent in the background.
+	for i := 0; i < 100 && server.count() == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	msg := server.message("owner@example.com")
+	if !strings.Contains(msg, "https://example.com/my_first_post.html") || !strings.Contains(msg, "Hello") {
+		t.Errorf("unexpected notification: %s", msg)
+	}
+}
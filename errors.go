@@ -0,0 +1,118 @@
+package ephemeris
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RenderError records the failure to render a single output file.
+type RenderError struct {
+	// Output is the file which failed to render, relative to the
+	// output-directory.
+	Output string
+
+	// Template is the name of the template used, if any.
+	Template string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the error, with the output and template which failed.
+func (r *RenderError) Error() string {
+	if r.Template == "" {
+		return fmt.Sprintf("%s: %s", r.Output, r.Err.Error())
+	}
+	return fmt.Sprintf("%s (%s): %s", r.Output, r.Template, r.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (r *RenderError) Unwrap() error {
+	return r.Err
+}
+
+// Errors holds several errors, such as those from each of the parts of a
+// build which failed.
+type Errors []error
+
+// Error returns each of the errors, one per line.
+func (e Errors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Group runs a collection of tasks, such as the generation of each part
+// of a site, concurrently.
+//
+// When a task fails the context passed to the others is cancelled, so
+// they may stop early, and the errors of every task which failed are
+// collected.  A zero Group is not valid, use NewGroup.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	errors Errors
+}
+
+// NewGroup returns a new group, whose tasks are given a context derived
+// from the given one.
+func NewGroup(ctx context.Context) *Group {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Go runs the given task in a new goroutine.
+//
+// If the task fails its error is prefixed with the given name, unless
+// the failure was caused by another task failing first.
+func (g *Group) Go(name string, task func(ctx context.Context) error) {
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		err := task(g.ctx)
+		if err == nil {
+			return
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		// Don't report tasks which stopped because another failed.
+		if err == context.Canceled && g.ctx.Err() != nil && len(g.errors) > 0 {
+			return
+		}
+
+		g.errors = append(g.errors, fmt.Errorf("%s: %w", name, err))
+		g.cancel()
+	}()
+}
+
+// Wait waits for every task to complete, and returns the errors of those
+// which failed, as Errors sorted by their message, or nil if they all
+// succeeded.
+func (g *Group) Wait() error {
+
+	g.wg.Wait()
+	g.cancel()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.errors) == 0 {
+		return nil
+	}
+	sort.Slice(g.errors, func(i, j int) bool {
+		return g.errors[i].Error() < g.errors[j].Error()
+	})
+	return g.errors
+}
@@ -0,0 +1,92 @@
+package ephemeris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Test the formatting of render errors.
+func TestRenderError(t *testing.T) {
+
+	base := fmt.Errorf("function \"FOO\" not defined")
+
+	err := error(&RenderError{Output: "tags/go/index.html", Template: "tag_page.tmpl", Err: base})
+	if err.Error() != `tags/go/index.html (tag_page.tmpl): function "FOO" not defined` {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+	if !errors.Is(err, base) {
+		t.Errorf("error didn't wrap the underlying error")
+	}
+
+	err = &RenderError{Output: "robots.txt", Err: base}
+	if err.Error() != `robots.txt: function "FOO" not defined` {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+}
+
+// Test running a group of tasks which succeed.
+func TestGroup(t *testing.T) {
+
+	g := NewGroup(context.Background())
+
+	done := make(chan string, 3)
+	for _, name := range []string{"one", "two", "three"} {
+		name := name
+		g.Go(name, func(ctx context.Context) error {
+			done <- name
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if len(done) != 3 {
+		t.Errorf("not every task ran")
+	}
+}
+
+// Test that failures are collected, and cancel the other tasks.
+func TestGroupFailure(t *testing.T) {
+
+	g := NewGroup(context.Background())
+
+	// Two tasks fail.
+	g.Go("tags", func(ctx context.Context) error {
+		return &RenderError{Output: "tags/index.html", Template: "tags.tmpl", Err: fmt.Errorf("broken")}
+	})
+	g.Go("archive", func(ctx context.Context) error {
+		return fmt.Errorf("also broken")
+	})
+
+	// Another waits to be cancelled, and so isn't reported.
+	g.Go("entries", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+			return fmt.Errorf("wasn't cancelled")
+		}
+	})
+
+	err := g.Wait()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	all, ok := err.(Errors)
+	if !ok || len(all) != 2 {
+		t.Fatalf("unexpected errors %v", err)
+	}
+	if err.Error() != "archive: also broken\ntags: tags/index.html (tags.tmpl): broken" {
+		t.Errorf("unexpected error:\n%s", err.Error())
+	}
+
+	var render *RenderError
+	if !errors.As(all[1], &render) || render.Template != "tags.tmpl" {
+		t.Errorf("the render error wasn't preserved")
+	}
+}
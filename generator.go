@@ -0,0 +1,1121 @@
+package ephemeris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultRecentPosts is the number of entries shown upon the index, in
+// the feed, and in the sidebar of each page, if none is specified.
+const DefaultRecentPosts = 10
+
+// GeneratorConfig holds the settings which affect the generated site.
+type GeneratorConfig struct {
+	// Prefix is the URL-prefix of the site, for example
+	// https://blog.steve.fi/
+	Prefix string
+
+	// CommentAPI is the endpoint which comments are submitted to, if
+	// comments are accepted.
+	CommentAPI string
+
+	// WebmentionAPI is the endpoint which receives webmentions, if
+	// any.
+	WebmentionAPI string
+
+	// AddComments controls whether the "add comment" form is shown
+	// upon the entries which accept comments.
+	AddComments bool
+
+	// RecentPosts is the number of entries shown upon the index, and
+	// in the sidebar, defaulting to DefaultRecentPosts.
+	RecentPosts int
+
+	// RecentComments is the number of comments shown upon the
+	// recent-comments page, and in its feed.  If this is zero, or
+	// negative, neither is generated.
+	RecentComments int
+
+	// RelatedPosts is the number of related posts shown upon each
+	// entry.
+	RelatedPosts int
+
+	// Robots holds the lines of the robots.txt file.
+	Robots []string
+
+	// Jobs is the number of files, across every part of the site,
+	// which are rendered concurrently, defaulting to the number of
+	// CPUs.
+	Jobs int
+}
+
+// Generator renders a site, using a set of templates, into an output.
+//
+// The parts of the site may be written individually, via the Write
+// methods, or all together via Generate.
+type Generator struct {
+	// Config holds the settings of the generated site.
+	Config GeneratorConfig
+
+	// Templates holds the templates used to render each page.  See
+	// LoadTemplates.
+	Templates *template.Template
+
+	// Output is where the site is written to.
+	Output OutputFS
+
+	// Site holds the entries, and comments, of the site.
+	Site *Ephemeris
+
+	// Pages holds the standalone pages of the site.
+	Pages []Page
+
+	// Manifest records each file which is written, if it is set.
+	Manifest *Manifest
+
+	// slots bounds the number of files which are rendered at once,
+	// across every part of the site, to Config.Jobs.
+	slots chan struct{}
+
+	// The data shared between the parts of the site.
+	entries    []BlogEntry
+	recent     []BlogEntry
+	comments   []EntryComment
+	related    map[string][]BlogEntry
+	navigation map[string]EntryNavigation
+	series     []*Series
+}
+
+// NewGenerator creates a generator for the given site, and pages.
+func NewGenerator(site *Ephemeris, pages []Page, config GeneratorConfig, templates *template.Template, output OutputFS) *Generator {
+
+	if config.RecentPosts <= 0 {
+		config.RecentPosts = DefaultRecentPosts
+	}
+	if config.Jobs <= 0 {
+		config.Jobs = runtime.NumCPU()
+	}
+
+	g := &Generator{
+		Config:    config,
+		Templates: templates,
+		Output:    output,
+		Site:      site,
+		Pages:     pages,
+		slots:     make(chan struct{}, config.Jobs),
+	}
+
+	// Find the data which is shared between the parts of the site
+	// up-front, since finding the recent entries sorts them.
+	g.recent = site.Recent(config.RecentPosts)
+	g.entries = site.Entries()
+	g.comments = site.RecentComments(config.RecentComments)
+	g.related = site.RelatedEntries(config.RelatedPosts)
+	g.navigation = site.Navigation()
+	g.series = site.AllSeries()
+
+	return g
+}
+
+// Generate writes every part of the site concurrently.
+//
+// If any part fails the others are cancelled, and the errors of every
+// part which failed are returned, as Errors.
+func (g *Generator) Generate(ctx context.Context) error {
+
+	group := NewGroup(ctx)
+
+	group.Go("tag-pages", g.WriteTags)
+	group.Go("series-pages", g.WriteSeries)
+	group.Go("archive-pages", g.WriteArchive)
+	group.Go("index", g.WriteIndex)
+	group.Go("feed", g.WriteRSS)
+	group.Go("comments", g.WriteComments)
+	group.Go("blog-posts", g.WriteEntries)
+	group.Go("pages", g.WritePages)
+	group.Go("avatars", g.WriteAvatars)
+	group.Go("sitemap", g.WriteSitemap)
+
+	return group.Wait()
+}
+
+// acquire waits until fewer than Config.Jobs files are being rendered,
+// and returns the function which must be called once the file has been
+// rendered.
+//
+// Each part of the site, and each worker rendering entries, tag-pages,
+// and archive-pages, shares the same slots, so that the number of jobs
+// bounds the whole generator.
+//
+// If the context is cancelled while waiting its error is returned, so
+// that the parts of a failed build stop without rendering anything more.
+func (g *Generator) acquire(ctx context.Context) (func(), error) {
+	if g.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case g.slots <- struct{}{}:
+		return func() { <-g.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// create creates the named output file, recording it in the manifest.
+func (g *Generator) create(name string) (io.WriteCloser, error) {
+	if g.Manifest != nil {
+		g.Manifest.Add(name)
+	}
+	return g.Output.Create(name)
+}
+
+// render renders the named template into the named output file.
+//
+// Failures are returned as a RenderError, which records the file and
+// template which failed.
+func (g *Generator) render(ctx context.Context, name string, template string, data interface{}) error {
+
+	release, err := g.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	output, err := g.create(name)
+	if err != nil {
+		return &RenderError{Output: name, Err: err}
+	}
+
+	err = g.Templates.ExecuteTemplate(output, template, data)
+	if err != nil {
+		output.Close()
+		return &RenderError{Output: name, Template: template, Err: err}
+	}
+
+	err = output.Close()
+	if err != nil {
+		return &RenderError{Output: name, Err: err}
+	}
+	return nil
+}
+
+// write writes the given data to the named output file.
+func (g *Generator) write(ctx context.Context, name string, data []byte) error {
+
+	release, err := g.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	output, err := g.create(name)
+	if err == nil {
+		_, err = output.Write(data)
+		if cerr := output.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return &RenderError{Output: name, Err: err}
+	}
+	return nil
+}
+
+// symlink creates the named symlink, recording it in the manifest.
+func (g *Generator) symlink(target string, name string) error {
+	if g.Manifest != nil {
+		g.Manifest.Add(name)
+	}
+	return g.Output.Symlink(target, name)
+}
+
+// LoadTemplates loads every template within the given filesystem, naming
+// each after its path, such as "entry.tmpl" or "inc/css.tmpl".
+//
+// The static/ directory, which holds assets rather than templates, is
+// skipped.
+//
+// In addition to loading the templates we also populate a function-map,
+// to allow various functions to be made available to all templates.
+//
+// The functions defined are:
+//
+// ISO8601          - Needed for RSS generation.
+// LOWER            - Lower-case a string.  Used for link-generation.
+// ESCAPE           - Escape HTML-text for RSS_generation too.
+// RECENT_POST_DATE - The date format used for the "most recent entries" list in the sidebar.
+// BLOG_POST_DATE   - The format used in the index/archive/tag-view.
+func LoadTemplates(fsys fs.FS, config GeneratorConfig) (*template.Template, error) {
+
+	// Create a helper-template, with no name.
+	t := template.New("").Funcs(template.FuncMap{
+
+		// Date-format for RSS feed
+		"ISO8601": func(d time.Time) string {
+			return (fmt.Sprintf("%v", d.Format(time.RFC3339)))
+		},
+
+		// Escape HTML in RSS feed
+		"ESCAPE": func(in string) string {
+			return (html.EscapeString(in))
+		},
+
+		// Escape link name.
+		"ESCAPE_LINK": func(in string) string {
+			return (url.PathEscape(in))
+		},
+
+		// Convert a string to lower-case.
+		//
+		// This is used to make sure all links point to their
+		// lower-cased version of the URL.
+		//
+		"LOWER": func(in string) string {
+			return (strings.ToLower(in))
+		},
+
+		// Prefix of the blog - i.e. URL to prepend to links
+		"PREFIX": func() string {
+			return config.Prefix
+		},
+
+		// Endpoint which receives webmentions, if any.
+		"WEBMENTION_API": func() string {
+			return config.WebmentionAPI
+		},
+
+		// Date used on "recent posts"
+		"RECENT_POST_DATE": func(d time.Time) string {
+			year, month, day := d.Date()
+			return (fmt.Sprintf("%d %s %d", day, month.String(), year))
+		},
+
+		// Date used on all blog posts.
+		"BLOG_POST_DATE": func(d time.Time) string {
+			year, month, day := d.Date()
+			return (fmt.Sprintf("%d %s %d %02d:%02d", day, month.String(), year, d.Hour(), d.Minute()))
+		},
+
+		// Date used in metadata, such as OpenGraph tags.
+		"RFC3339": func(d time.Time) string {
+			return d.Format(time.RFC3339)
+		},
+
+		// Date used on comments.
+		"COMMENT_POST_DATE": func(d time.Time) string {
+			year, month, day := d.Date()
+			return (fmt.Sprintf("at %02d:%02d on %d %s %d", d.Hour(), d.Minute(), day, month.String(), year))
+		},
+	})
+
+	// Now load all the templates
+	err := fs.WalkDir(fsys, ".", func(pth string, d fs.DirEntry, err error) error {
+		// Error?  Then return it
+		if err != nil {
+			return err
+		}
+
+		// Directory?  Ignore it.
+		//
+		// Static assets aren't templates, so skip them too.
+		if d.IsDir() {
+			if pth == StaticDirectory {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		// Get the file contents.
+		data, err := fs.ReadFile(fsys, pth)
+		if err != nil {
+			return err
+		}
+
+		// Add the data + template
+		t = t.New(pth)
+		t, err = t.Parse(string(data))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	return t, err
+}
+
+// WriteTags writes out the tag-specific pages, and the tag-cloud.
+//
+// First of all build up a list of tags, then render
+// a template for each one.
+func (g *Generator) WriteTags(ctx context.Context) error {
+
+	posts, recentPosts := g.entries, g.recent
+
+	//
+	// OK we'll now try to build up a list of tags.
+	//
+	// The tag list will consist of:
+	//
+	//   tagName -> [array-index-1, array-index-2, ...]
+	//
+	// Where the array indexes are the indexes of the posts
+	// array which contain the tag.
+	//
+	// We do this because it is lightweight.
+	//
+	tagMap := make(map[string][]int)
+
+	//
+	// For each post ..
+	//
+	for i, e := range posts {
+
+		// If it has tags ..
+		if len(e.Tags) > 0 {
+
+			// For each tag
+			for _, tag := range e.Tags {
+
+				// Add the entry-number to the tag-list
+				existing := tagMap[tag]
+				existing = append(existing, i)
+				tagMap[tag] = existing
+			}
+		}
+	}
+
+	//
+	//  Page-Structure for a tag-page view.
+	//
+	//  i.e. /tags/z80
+	//
+	type TagPage struct {
+
+		// Tag contains the name of the tag.
+		Tag string
+
+		// Entries holds entries having the given tag
+		Entries []BlogEntry
+
+		// RecentPosts contains data for our sidebar.
+		RecentPosts []BlogEntry
+	}
+
+	//
+	// We want a sorted list of tags
+	//
+	var tagNames []string
+	for key := range tagMap {
+		tagNames = append(tagNames, key)
+	}
+	sort.Strings(tagNames)
+
+	//
+	// Create a per-page tag-template, rendering several pages
+	// at once.
+	//
+	err := forEach(ctx, g.Config.Jobs, len(tagNames), func(i int) error {
+
+		key := tagNames[i]
+
+		// Create an instance.
+		pageData := TagPage{Tag: key, RecentPosts: recentPosts}
+
+		// Add the entries
+		for _, e := range tagMap[key] {
+			pageData.Entries = append(pageData.Entries, posts[e])
+		}
+
+		// Sort by date - tags will be viewed in creation-order
+		sort.SliceStable(pageData.Entries, func(i, j int) bool {
+			a := pageData.Entries[i].Date
+			b := pageData.Entries[j].Date
+			return a.Before(b)
+		})
+
+		//
+		// Render the template into the output file.
+		//
+		return g.render(ctx, path.Join("tags", key, "index.html"), "tag_page.tmpl", pageData)
+	})
+	if err != nil {
+		return err
+	}
+
+	//
+	// /tags/index.html
+	//
+
+	//
+	// We want to have a tag-cloud for the /tags/index.html page.
+	//
+	type TagMap struct {
+		// Tag contains the name of the string.
+		Tag string
+
+		// TSize contains the text-size of the entry in the cloud.
+		TSize int
+
+		// Count shows how many times the tag was used.
+		Count int
+	}
+
+	//
+	// Page-data: The tag-data, and the recent-posts list.
+	//
+	type TagCloudPage struct {
+		Tags        []TagMap
+		RecentPosts []BlogEntry
+	}
+	var tagCloud TagCloudPage
+	tagCloud.RecentPosts = recentPosts
+
+	//
+	// Now we have a sorted list of unique tag-names we can build up
+	// that array for the template-page
+	//
+	for _, tag := range tagNames {
+		count := len(tagMap[tag])
+		size := (count * 5) + 5
+		if size > 60 {
+			size = 60
+		}
+		tagCloud.Tags = append(tagCloud.Tags, TagMap{Tag: tag, Count: count, TSize: size})
+	}
+
+	//
+	// Render the template into the output file.
+	//
+	return g.render(ctx, path.Join("tags", "index.html"), "tags.tmpl", tagCloud)
+}
+
+// WriteSeries writes the index-page of each series of posts, which lists
+// the parts of the series in order.
+func (g *Generator) WriteSeries(ctx context.Context) error {
+
+	series, recentPosts := g.series, g.recent
+
+	//
+	//  Page-Structure for a series-page view.
+	//
+	//  i.e. /series/writing_a_compiler/
+	//
+	type SeriesPage struct {
+
+		// Series holds the series, and its entries.
+		Series *Series
+
+		// RecentPosts contains data for our sidebar.
+		RecentPosts []BlogEntry
+	}
+
+	var pageData SeriesPage
+	pageData.RecentPosts = recentPosts
+
+	for _, s := range series {
+
+		// Stop early if the build has failed elsewhere.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dir := path.Join(SeriesDirectory, s.Slug)
+
+		pageData.Series = s
+
+		//
+		// Render the template into the output file.
+		//
+		err := g.render(ctx, path.Join(dir, "index.html"), "series_page.tmpl", pageData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteArchive outputs a year/month page for each distinct period in which
+// we have posts, and the archive-index.
+func (g *Generator) WriteArchive(ctx context.Context) error {
+
+	posts, recentPosts := g.entries, g.recent
+
+	//
+	// We'll build up a list of year/mon pages.
+	//
+	// The map will consist of:
+	//
+	//   year/mon -> [array-index-1, array-index-2, ...]
+	//
+	// Where the array indexes are the indexes of the posts
+	// array which were posted in the given month.
+	//
+	archiveMap := make(map[string][]int)
+
+	for i, e := range posts {
+
+		// The key is "YYYY/NN"
+		key := e.Year() + "/" + e.MonthNumber()
+
+		existing := archiveMap[key]
+		existing = append(existing, i)
+		archiveMap[key] = existing
+	}
+
+	//
+	// Archive page contains data to be shown in
+	// the archive page of:
+	//
+	//  /archive/2019/03/
+	//
+	type PageData struct {
+
+		// Year contains the year we're covering.
+		Year string
+
+		// Month contains the month we're covering.
+		Month string
+
+		// Entries holds the entries in the given year/month
+		Entries []BlogEntry
+
+		// RecentPosts holds data for the sidebar.
+		RecentPosts []BlogEntry
+	}
+
+	//
+	// The months, in order.
+	//
+	var months []string
+	for key := range archiveMap {
+		months = append(months, key)
+	}
+	sort.Strings(months)
+
+	//
+	// Create a per-page output, rendering several pages at once.
+	//
+	err := forEach(ctx, g.Config.Jobs, len(months), func(i int) error {
+
+		key := months[i]
+
+		// Create an instance of the object.
+		pageData := PageData{RecentPosts: recentPosts}
+
+		// Add the entries
+		for _, e := range archiveMap[key] {
+			pageData.Entries = append(pageData.Entries, posts[e])
+
+			//
+			// Our archiveMap contains keys of the form:
+			//
+			//    year/mon
+			//
+			// But when we present this to the viewers we want
+			// to show the month-name, and year name.
+			//
+			// We can calculate that from the `Date` field
+			// in the post itself :)
+			//
+			pageData.Year = posts[e].Year()
+			pageData.Month = posts[e].MonthName()
+		}
+
+		// Sort by date - posts will be in order they've been written
+		sort.SliceStable(pageData.Entries, func(i, j int) bool {
+			a := pageData.Entries[i].Date
+			b := pageData.Entries[j].Date
+			return a.Before(b)
+		})
+
+		//
+		// Render the template into the output file.
+		//
+		return g.render(ctx, path.Join("archive", key, "index.html"), "archive_page.tmpl", pageData)
+	})
+	if err != nil {
+		return err
+	}
+
+	//
+	// Page data for the archive-index.
+	//
+	//  i.e. /archive/index.html
+	//
+	type ArchiveCount struct {
+		Year      string
+		Month     string
+		MonthName string
+		Count     string
+	}
+
+	mappy := make(map[string][]ArchiveCount)
+
+	type ArchiveIndex struct {
+		Year        string
+		Data        []ArchiveCount
+		RecentPosts []BlogEntry
+	}
+	var ai []ArchiveIndex
+
+	//
+	// Build up the count of posts in the given month/year.
+	//
+	for _, e := range archiveMap {
+
+		//
+		// Since all the posts are have the same year + month
+		// pair we're able to just use the first entry in
+		// each returned set.
+		//
+		y := posts[e[0]].Year()
+		m := posts[e[0]].MonthNumber()
+		n := posts[e[0]].MonthName()
+		c := fmt.Sprintf("%d", len(e))
+
+		// Append
+		existing := mappy[y]
+		existing = append(existing, ArchiveCount{
+			Count:     c,
+			Year:      y,
+			Month:     m,
+			MonthName: n,
+		})
+
+		mappy[y] = existing
+	}
+
+	// Sort the entries now we've generated them.
+	for k, entries := range mappy {
+
+		vals := mappy[k]
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Month < entries[j].Month
+		})
+		mappy[k] = vals
+
+	}
+
+	//
+	// Now a data-structure
+	//
+	// sorted keys
+	//
+	var years []string
+	for year := range mappy {
+		years = append(years, year)
+	}
+	sort.Strings(years)
+
+	//
+	// For each year we add the data
+	//
+	for _, year := range years {
+		ai = append(ai, ArchiveIndex{Year: year, Data: mappy[year], RecentPosts: recentPosts})
+	}
+
+	//
+	// Render the template into the output file.
+	//
+	return g.render(ctx, path.Join("archive", "index.html"), "archive.tmpl", ai)
+}
+
+// WriteIndex outputs the /index.html file.
+//
+// We don't need to sort, or limit ourselves here, because we only use
+// the "most recent posts" we've already discovered.
+func (g *Generator) WriteIndex(ctx context.Context) error {
+
+	recentPosts := g.recent
+
+	// Page-structure for the site.
+	type Recent struct {
+
+		// Entries has the most recent entries.
+		Entries []BlogEntry
+
+		// RecentPosts has the same data, but for
+		// the side-bar.  It is redundant.
+		RecentPosts []BlogEntry
+	}
+
+	//
+	// The data we'll store for the page.
+	//
+	// Our front-page shows the same number of posts as
+	// the recent-list in the sidebar, so we don't need
+	// to do anything special here, we show the same
+	// list for both of them.
+	//
+	var pageData Recent
+	pageData.Entries = recentPosts
+	pageData.RecentPosts = recentPosts
+
+	//
+	// Render the template into the output file.
+	//
+	err := g.render(ctx, "index.html", "index.tmpl", pageData)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+// WriteRSS outputs the /index.rss file.
+//
+// We don't need to sort, or limit ourselves here, because we only use
+// the "most recent posts" we've already discovered.
+func (g *Generator) WriteRSS(ctx context.Context) error {
+
+	recentPosts := g.recent
+
+	// Page-structure for the site.
+	type Recent struct {
+
+		// Entries has the most recent entries.
+		Entries []BlogEntry
+
+		// RecentPosts has the same data, but for
+		// the side-bar.  It is redundant.
+		RecentPosts []BlogEntry
+	}
+
+	//
+	// The data we'll store for the page.
+	//
+	// Our front-page shows the same number of posts as
+	// the recent-list in the sidebar, so we don't need
+	// to do anything special here, we show the same
+	// list for both of them.
+	//
+	var pageData Recent
+	pageData.Entries = recentPosts
+	pageData.RecentPosts = recentPosts
+
+	//
+	// Render the template into the output file.
+	//
+	err := g.render(ctx, "index.rss", "index.rss", pageData)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+// WriteComments outputs the /comments/index.html page, and the
+// /comments.rss feed, which both show the most recent comments upon
+// any entry, unless RecentComments disables them.
+func (g *Generator) WriteComments(ctx context.Context) error {
+
+	if g.Config.RecentComments <= 0 {
+		return nil
+	}
+
+	comments, recentPosts := g.comments, g.recent
+
+	// Page-structure for the comments.
+	type Recent struct {
+
+		// Comments has the most recent comments.
+		Comments []EntryComment
+
+		// RecentPosts contains data for our sidebar.
+		RecentPosts []BlogEntry
+	}
+
+	var pageData Recent
+	pageData.Comments = comments
+	pageData.RecentPosts = recentPosts
+
+	//
+	// Render the template into the output file.
+	//
+	err := g.render(ctx, path.Join("comments", "index.html"), "comments.tmpl", pageData)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Render the feed.
+	//
+	return g.render(ctx, "comments.rss", "comments.rss", pageData)
+}
+
+// WriteEntries outputs one page for each entry.
+//
+// If comments are enabled then we'll add the comments to the entries,
+// and we'll ensure we setup the comment CGI path.
+//
+// Each entry also shows its related posts, the entries either side of
+// it, and the series it is part of.
+func (g *Generator) WriteEntries(ctx context.Context) error {
+
+	posts, recentPosts := g.entries, g.recent
+	related, navigation, seriesOf := g.related, g.navigation, g.Site.SeriesOf
+
+	// Page-structure for the site.
+	type Recent struct {
+
+		// The blog-entry
+		Entry BlogEntry
+
+		// Should we display the add-comment form for this post?
+		AddComment bool
+
+		// CGI link
+		CommentAPI string
+
+		// The recent posts for the sidebar.
+		RecentPosts []BlogEntry
+
+		// The posts related to this one.
+		RelatedPosts []BlogEntry
+
+		// The posts published before, and after, this one.
+		Prev *BlogEntry
+		Next *BlogEntry
+
+		// The posts before, and after, this one within each tag.
+		TagNavigation []TagNavigation
+
+		// The series this post is part of, if any.
+		Series *Series
+	}
+
+	// The time against which comment-periods are tested.
+	now := time.Now()
+
+	//
+	// Create a per-page output, rendering several entries at
+	// once.
+	//
+	return forEach(ctx, g.Config.Jobs, len(posts), func(i int) error {
+
+		entry := posts[i]
+
+		//
+		// The data we use for output.
+		//
+		var pageData Recent
+
+		// The most recent posts
+		pageData.RecentPosts = recentPosts
+
+		// The site prefix, and the link to the CGI form for
+		// comment-submission.
+		pageData.CommentAPI = g.Config.CommentAPI
+
+		//
+		// Populate the page-data with this entry.
+		//
+		pageData.Entry = entry
+		pageData.RelatedPosts = related[entry.Path]
+		pageData.Prev = navigation[entry.Path].Prev
+		pageData.Next = navigation[entry.Path].Next
+		pageData.TagNavigation = navigation[entry.Path].Tags
+		pageData.Series = seriesOf(entry)
+
+		//
+		// Posts have comments enabled if they're open, which
+		// is determined by their header and the comment-period.
+		//
+		pageData.AddComment = g.Config.AddComments && CommentsOpen(entry, recentPosts[0], g.Site.Options.CommentDays, now)
+
+		//
+		// We have a link and that points to a filename.
+		//
+		// We get the latter from the former by removing the
+		// prefix.
+		//
+		u, err := url.Parse(entry.Link)
+		if err != nil {
+			return err
+		}
+
+		// Get the path.
+		name := u.RequestURI()
+
+		// Remove the leading slash.
+		name = strings.TrimPrefix(name, "/")
+
+		//
+		// Lower-case the name and write to that too
+		//
+		dest := strings.ToLower(name)
+
+		//
+		// Render the template into the output file.
+		//
+		err = g.render(ctx, dest, "entry.tmpl", pageData)
+		if err != nil {
+			return err
+		}
+
+		//
+		// Create symlink, if the name isn't already lower-case.
+		//
+		// This fails on filesystems which ignore case, and when
+		// the link remains from a previous build, which is fine,
+		// but any other failure is reported.
+		//
+		if name != dest {
+			err = g.symlink(dest, name)
+			if err != nil && !errors.Is(err, fs.ErrExist) {
+				return &RenderError{Output: name, Err: err}
+			}
+		}
+
+		return nil
+	})
+}
+
+// WritePages writes out each standalone page, beneath its own directory.
+func (g *Generator) WritePages(ctx context.Context) error {
+
+	pages, recentPosts := g.Pages, g.recent
+
+	// Page-structure for a standalone page.
+	type PageData struct {
+
+		// The page
+		Page Page
+
+		// The recent posts for the sidebar.
+		RecentPosts []BlogEntry
+	}
+
+	var pageData PageData
+	pageData.RecentPosts = recentPosts
+
+	for _, page := range pages {
+
+		// Stop early if the build has failed elsewhere.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		pageData.Page = page
+
+		//
+		// Render the template into the output file.
+		//
+		err := g.render(ctx, filepath.ToSlash(page.Output), "page.tmpl", pageData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteAvatars writes out the identicon for each comment which has one.
+//
+// Comment-submitters who have posted more than once will share an icon,
+// so we only write each icon a single time.
+func (g *Generator) WriteAvatars(ctx context.Context) error {
+
+	posts := g.entries
+
+	seen := make(map[string]bool)
+
+	for _, entry := range posts {
+
+		// Stop early if the build has failed elsewhere.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, comment := range entry.CommentData {
+
+			// No identicon, or already written?
+			if comment.AvatarID == "" || seen[comment.AvatarID] {
+				continue
+			}
+			seen[comment.AvatarID] = true
+
+			//
+			// Write the icon.
+			//
+			svg := Identicon(comment.AvatarID)
+			err := g.write(ctx, path.Join(AvatarDirectory, comment.AvatarID+".svg"), []byte(svg))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteSitemap writes the /sitemap.xml file, listing every page we
+// generate, and the /robots.txt file which references it.
+func (g *Generator) WriteSitemap(ctx context.Context) error {
+
+	site, pages := g.Site, g.Pages
+
+	urls := site.Sitemap(pages)
+
+	//
+	// The recent-comments page is only generated if enabled, and
+	// changes whenever a comment is left.
+	//
+	if g.Config.RecentComments > 0 {
+		comments := SitemapURL{Loc: site.Prefix + "comments/"}
+		if len(g.comments) > 0 {
+			comments.LastMod = g.comments[0].Date
+		}
+		urls = append(urls, comments)
+
+		sort.Slice(urls, func(i, j int) bool {
+			return urls[i].Loc < urls[j].Loc
+		})
+	}
+
+	//
+	// Create the sitemap.
+	//
+	release, err := g.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	output, err := g.create("sitemap.xml")
+	if err != nil {
+		release()
+		return &RenderError{Output: "sitemap.xml", Err: err}
+	}
+
+	err = WriteSitemap(output, urls)
+	if err == nil {
+		err = output.Close()
+	} else {
+		output.Close()
+	}
+	release()
+	if err != nil {
+		return &RenderError{Output: "sitemap.xml", Err: err}
+	}
+
+	//
+	// Create robots.txt, with any rules from our configuration.
+	//
+	robots := Robots(g.Config.Prefix, strings.Join(g.Config.Robots, "\n"))
+	return g.write(ctx, "robots.txt", []byte(robots))
+}
@@ -0,0 +1,394 @@
+package ephemeris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testTemplates returns a minimal theme, with one template for each
+// page we generate.
+func testTemplates() fstest.MapFS {
+	return fstest.MapFS{
+		"entry.tmpl":         {Data: []byte(`{{template "inc/title.tmpl" .Entry}}{{if .AddComment}} comments{{end}}`)},
+		"inc/title.tmpl":     {Data: []byte(`<h1>{{ESCAPE .Title}}</h1>`)},
+		"tag_page.tmpl":      {Data: []byte(`{{.Tag}}:{{range .Entries}} {{.Title}}{{end}}`)},
+		"tags.tmpl":          {Data: []byte(`{{range .Tags}}{{.Tag}}={{.Count}} {{end}}`)},
+		"archive_page.tmpl":  {Data: []byte(`{{.Month}} {{.Year}}`)},
+		"archive.tmpl":       {Data: []byte(`{{range .}}{{.Year}} {{end}}`)},
+		"index.tmpl":         {Data: []byte(`{{PREFIX}}{{range .Entries}} {{.Title}}{{end}}`)},
+		"index.rss":          {Data: []byte(`{{range .Entries}}{{ISO8601 .Date}}{{end}}`)},
+		"comments.tmpl":      {Data: []byte(`{{len .Comments}} comments`)},
+		"comments.rss":       {Data: []byte(`{{len .Comments}} comments`)},
+		"series_page.tmpl":   {Data: []byte(`{{.Series.Name}}`)},
+		"page.tmpl":          {Data: []byte(`{{.Page.Title}}`)},
+		"static/style.css":   {Data: []byte(`{{ this isn't a template }}`)},
+		"static/img/cat.png": {Data: []byte(`not a template either`)},
+	}
+}
+
+// testGenerator returns a generator for a small site, which writes to a
+// temporary directory.
+func testGenerator(t *testing.T, templates fstest.MapFS) (*Generator, string) {
+
+	config := GeneratorConfig{
+		Prefix:         "https://example.com/",
+		AddComments:    true,
+		RecentComments: 10,
+	}
+
+	tmpl, err := LoadTemplates(templates, config)
+	if err != nil {
+		t.Fatalf("failed to load templates: %s", err.Error())
+	}
+
+	date := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	site := &Ephemeris{Prefix: config.Prefix}
+	site.BlogEntries = []BlogEntry{
+		{Title: "First Post", Path: "first.txt", Link: "https://example.com/First_Post.html", Date: date, Tags: []string{"go"}},
+		{Title: "Second <Post>", Path: "second.txt", Link: "https://example.com/second_post.html", Date: date.AddDate(0, 1, 0), Tags: []string{"go", "misc"}, Series: "Example"},
+	}
+	site.SeriesIndex = buildSeriesIndex(site.BlogEntries, site.Prefix)
+
+	pages := []Page{{Title: "About", Output: filepath.Join("about", "index.html"), Link: "https://example.com/about/"}}
+
+	dir := t.TempDir()
+	return NewGenerator(site, pages, config, tmpl, DirOutput(dir)), dir
+}
+
+// Test generating a complete site.
+func TestGenerate(t *testing.T) {
+
+	g, dir := testGenerator(t, testTemplates())
+	g.Manifest = &Manifest{}
+
+	err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"first_post.html":            "<h1>First Post</h1>",
+		"second_post.html":           "<h1>Second &lt;Post&gt;</h1> comments",
+		"tags/go/index.html":         "go: First Post Second <Post>",
+		"tags/index.html":            "go=2 misc=1 ",
+		"archive/2020/04/index.html": "April 2020",
+		"archive/index.html":         "2020 ",
+		"index.html":                 "https://example.com/ Second <Post> First Post",
+		"index.rss":                  "2020-04-01T12:00:00Z2020-03-01T12:00:00Z",
+		"comments/index.html":        "0 comments",
+		"series/example/index.html":  "Example",
+		"about/index.html":           "About",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("failed to read %s: %s", name, err.Error())
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s contained %q not %q", name, data, content)
+		}
+		if !g.Manifest.Contains(name) {
+			t.Errorf("%s wasn't recorded in the manifest", name)
+		}
+	}
+
+	// The mixed-case link is a symlink to the lower-cased entry.
+	if target, err := os.Readlink(filepath.Join(dir, "First_Post.html")); err != nil || target != "first_post.html" {
+		t.Errorf("unexpected symlink %s %v", target, err)
+	}
+
+	// The sitemap, and robots.txt, are present.
+	for _, name := range []string{"sitemap.xml", "robots.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s is missing", name)
+		}
+	}
+}
+
+// Test writing one part of a site.
+func TestGeneratorWriteIndex(t *testing.T) {
+
+	g, dir := testGenerator(t, testTemplates())
+
+	err := g.WriteIndex(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "index.html" {
+		t.Errorf("unexpected output %v", entries)
+	}
+}
+
+// Test the recent comments may be disabled.
+func TestGeneratorWriteCommentsDisabled(t *testing.T) {
+
+	g, dir := testGenerator(t, testTemplates())
+	g.Config.RecentComments = -1
+
+	err := g.WriteComments(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("unexpected output %v", entries)
+	}
+}
+
+// Test the recent-comments page is in the sitemap, when it is generated.
+func TestGeneratorWriteSitemapComments(t *testing.T) {
+
+	for _, count := range []int{10, -1} {
+		g, dir := testGenerator(t, testTemplates())
+		g.Config.RecentComments = count
+
+		err := g.WriteSitemap(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error %s", err.Error())
+		}
+
+		data, _ := os.ReadFile(filepath.Join(dir, "sitemap.xml"))
+		if strings.Contains(string(data), "https://example.com/comments/") != (count > 0) {
+			t.Errorf("unexpected sitemap with %d recent comments %s", count, data)
+		}
+	}
+}
+
+// Test that failures report the file, and template, which failed.
+func TestGenerateFailure(t *testing.T) {
+
+	templates := testTemplates()
+	templates["tag_page.tmpl"] = &fstest.MapFile{Data: []byte(`{{.Missing}}`)}
+
+	g, _ := testGenerator(t, templates)
+
+	err := g.Generate(context.Background())
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	// The other parts of the site are cancelled, rather than failing.
+	all, ok := err.(Errors)
+	if !ok || len(all) != 1 {
+		t.Fatalf("expected one error, got %v", err)
+	}
+	if !strings.HasPrefix(all[0].Error(), "tag-pages: tags/") {
+		t.Errorf("unexpected error %s", all[0].Error())
+	}
+
+	var render *RenderError
+	if !errors.As(all[0], &render) || render.Template != "tag_page.tmpl" {
+		t.Errorf("the render error wasn't preserved")
+	}
+}
+
+// Test that broken templates are reported when they're loaded.
+func TestLoadTemplatesFailure(t *testing.T) {
+
+	templates := testTemplates()
+	templates["index.tmpl"] = &fstest.MapFile{Data: []byte(`{{UNKNOWN}}`)}
+
+	_, err := LoadTemplates(templates, GeneratorConfig{})
+	if err == nil || !strings.Contains(err.Error(), "UNKNOWN") {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
+
+// Test generating a site entirely in memory.
+func TestGenerateMemory(t *testing.T) {
+
+	g, dir := testGenerator(t, testTemplates())
+
+	output := &MemoryOutput{}
+	g.Output = output
+
+	err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	// Nothing was written to disk.
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("unexpected files on disk %v", entries)
+	}
+
+	data, err := fs.ReadFile(output, "First_Post.html")
+	if err != nil || string(data) != "<h1>First Post</h1>" {
+		t.Errorf("unexpected entry %s %v", data, err)
+	}
+	data, err = fs.ReadFile(output, "tags/index.html")
+	if err != nil || string(data) != "go=2 misc=1 " {
+		t.Errorf("unexpected tags %s %v", data, err)
+	}
+}
+
+// Test that the output doesn't depend upon the number of jobs.
+func TestGenerateJobs(t *testing.T) {
+
+	var sites []map[string]string
+
+	for _, jobs := range []int{1, 8} {
+		g, _ := testGenerator(t, testTemplates())
+
+		// More entries, with the same date, than jobs.
+		for i := 0; i < 20; i++ {
+			entry := g.Site.BlogEntries[0]
+			entry.Title = fmt.Sprintf("Post %d", i)
+			entry.Link = fmt.Sprintf("https://example.com/post_%d.html", i)
+			g.Site.BlogEntries = append(g.Site.BlogEntries, entry)
+		}
+
+		g = NewGenerator(g.Site, g.Pages, GeneratorConfig{Prefix: g.Config.Prefix, Jobs: jobs}, g.Templates, &MemoryOutput{})
+		if g.Config.Jobs != jobs {
+			t.Fatalf("unexpected jobs %d", g.Config.Jobs)
+		}
+
+		err := g.Generate(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error %s", err.Error())
+		}
+
+		files := make(map[string]string)
+		fs.WalkDir(g.Output.(fs.FS), ".", func(name string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				data, _ := fs.ReadFile(g.Output.(fs.FS), name)
+				files[name] = string(data)
+			}
+			return err
+		})
+		sites = append(sites, files)
+	}
+
+	if len(sites[0]) < 30 || !reflect.DeepEqual(sites[0], sites[1]) {
+		t.Errorf("the output differed, or was missing")
+	}
+}
+
+// countingOutput records the most files which were open at once.
+type countingOutput struct {
+	MemoryOutput
+
+	mu   sync.Mutex
+	open int
+	max  int
+}
+
+// countingFile decrements the count of open files when it is closed.
+type countingFile struct {
+	io.WriteCloser
+	output *countingOutput
+}
+
+func (c *countingOutput) Create(name string) (io.WriteCloser, error) {
+	c.mu.Lock()
+	c.open++
+	if c.open > c.max {
+		c.max = c.open
+	}
+	c.mu.Unlock()
+
+	// Give the other parts of the site a chance to overlap.
+	time.Sleep(time.Millisecond)
+
+	f, err := c.MemoryOutput.Create(name)
+	return &countingFile{WriteCloser: f, output: c}, err
+}
+
+func (f *countingFile) Close() error {
+	f.output.mu.Lock()
+	f.output.open--
+	f.output.mu.Unlock()
+	return f.WriteCloser.Close()
+}
+
+// Test that the number of jobs bounds the whole generator, rather than
+// each part of the site.
+func TestGenerateJobsBound(t *testing.T) {
+
+	g, _ := testGenerator(t, testTemplates())
+	for i := 0; i < 20; i++ {
+		entry := g.Site.BlogEntries[0]
+		entry.Title = fmt.Sprintf("Post %d", i)
+		entry.Link = fmt.Sprintf("https://example.com/post_%d.html", i)
+		entry.Tags = []string{fmt.Sprintf("tag%d", i)}
+		entry.Date = entry.Date.AddDate(0, i, 0)
+		g.Site.BlogEntries = append(g.Site.BlogEntries, entry)
+	}
+
+	output := &countingOutput{}
+	g = NewGenerator(g.Site, g.Pages, GeneratorConfig{Prefix: g.Config.Prefix, Jobs: 2}, g.Templates, output)
+
+	err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if output.max < 1 || output.max > 2 {
+		t.Errorf("%d files were rendered at once", output.max)
+	}
+}
+
+// symlinkOutput is an OutputFS whose symlinks fail with the given error.
+type symlinkOutput struct {
+	OutputFS
+	err error
+}
+
+// Symlink fails.
+func (s *symlinkOutput) Symlink(target string, name string) error {
+	return &fs.PathError{Op: "symlink", Path: name, Err: s.err}
+}
+
+// Test that failing to link entries is reported, unless the link exists.
+func TestGenerateSymlinkFailure(t *testing.T) {
+
+	g, dir := testGenerator(t, testTemplates())
+
+	g.Output = &symlinkOutput{OutputFS: DirOutput(dir), err: fs.ErrExist}
+	err := g.WriteEntries(context.Background())
+	if err != nil {
+		t.Errorf("unexpected error for an existing link %s", err.Error())
+	}
+
+	g.Output = &symlinkOutput{OutputFS: DirOutput(dir), err: fs.ErrPermission}
+	err = g.WriteEntries(context.Background())
+
+	var render *RenderError
+	if !errors.As(err, &render) || render.Output != "First_Post.html" {
+		t.Errorf("expected the failed link to be reported, got %v", err)
+	}
+}
+
+// Test that waiting for a slot stops when the context is cancelled.
+func TestGeneratorAcquireCancelled(t *testing.T) {
+
+	g, _ := testGenerator(t, testTemplates())
+	g = NewGenerator(g.Site, g.Pages, GeneratorConfig{Jobs: 1}, g.Templates, g.Output)
+
+	release, err := g.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = g.acquire(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected the wait to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,496 @@
+package ephemeris
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// DefaultImageQuality is the quality of the JPEG variants we generate,
+// if none is specified.
+const DefaultImageQuality = 85
+
+// ImageProcessor generates resized variants of the images referenced by
+// posts, and rewrites the <img> tags which reference them to allow the
+// browser to choose the most suitable variant.
+//
+// Only JPEG and PNG images are processed, since they're the formats with
+// encoders in the standard library.  Other images are left alone.
+//
+// Variants are stored in a cache-directory, named after a hash of the
+// image they were generated from, so images are only resized when they
+// change, even if the output is removed between builds.
+type ImageProcessor struct {
+	// Widths holds the widths of the variants to generate.  Variants
+	// are only generated if they're narrower than the image.
+	Widths []int
+
+	// Quality is the quality of JPEG variants, from 1 to 100.
+	Quality int
+
+	// CachePath is the directory in which variants are cached.
+	CachePath string
+
+	// Source holds the images, by their path within the output, such
+	// as the StaticFiles which are copied there.
+	Source fs.FS
+
+	// Output is where the variants are written to, alongside the
+	// original images.
+	Output OutputFS
+
+	// Prefix is the URL-prefix of the blog.
+	Prefix string
+
+	// images holds the images we've processed, by path, so each is
+	// only processed once.
+	images map[string]*processedImage
+
+	// files holds the variants we've written, relative to the root of
+	// the output.
+	files []string
+}
+
+// processedImage holds the details of a single image.
+type processedImage struct {
+	// width and height are the dimensions of the image.
+	width  int
+	height int
+
+	// variants holds the names of the variants, by width.
+	variants map[int]string
+}
+
+// Rewrite processes the images referenced by the given HTML content, and
+// returns the content with their <img> tags rewritten.  The content is
+// written to the given directory, relative to the output, such as "."
+// for entries, so that relative links to images may be resolved.
+//
+// Each tag is given `width` and `height` attributes, if it has neither,
+// and a `srcset` attribute listing the variants, if it has none.
+func (p *ImageProcessor) Rewrite(content string, dir string) (string, error) {
+
+	var out strings.Builder
+
+	tokens := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := tokens.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+
+		raw := string(tokens.Raw())
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			out.WriteString(raw)
+			continue
+		}
+
+		tok := tokens.Token()
+		if tok.Data != "img" {
+			out.WriteString(raw)
+			continue
+		}
+
+		rewritten, err := p.rewriteTag(tok, dir)
+		if err != nil {
+			return content, err
+		}
+		if rewritten == nil {
+			out.WriteString(raw)
+			continue
+		}
+		out.WriteString(rewritten.String())
+	}
+
+	return out.String(), nil
+}
+
+// rewriteTag returns the rewritten version of a single <img> tag, or nil
+// if it should be left unchanged.
+func (p *ImageProcessor) rewriteTag(tok xhtml.Token, dir string) (*xhtml.Token, error) {
+
+	attrs := make(map[string]string)
+	for _, a := range tok.Attr {
+		attrs[a.Key] = a.Val
+	}
+
+	src, ok := attrs["src"]
+	if !ok {
+		return nil, nil
+	}
+
+	file := p.localPath(src, dir)
+	if file == "" {
+		return nil, nil
+	}
+
+	img, err := p.process(file)
+	if err != nil || img == nil {
+		return nil, err
+	}
+
+	changed := false
+
+	_, hasWidth := attrs["width"]
+	_, hasHeight := attrs["height"]
+	if !hasWidth && !hasHeight {
+		tok.Attr = append(tok.Attr,
+			xhtml.Attribute{Key: "width", Val: strconv.Itoa(img.width)},
+			xhtml.Attribute{Key: "height", Val: strconv.Itoa(img.height)})
+		changed = true
+	}
+
+	if _, ok := attrs["srcset"]; !ok && len(img.variants) > 0 {
+
+		// Variants are alongside the original.
+		dir := ""
+		if i := strings.LastIndex(src, "/"); i >= 0 {
+			dir = src[:i+1]
+		}
+
+		var widths []int
+		for w := range img.variants {
+			widths = append(widths, w)
+		}
+		sort.Ints(widths)
+
+		var set []string
+		for _, w := range widths {
+			set = append(set, fmt.Sprintf("%s%s %dw", dir, img.variants[w], w))
+		}
+		set = append(set, fmt.Sprintf("%s %dw", src, img.width))
+
+		tok.Attr = append(tok.Attr,
+			xhtml.Attribute{Key: "srcset", Val: strings.Join(set, ", ")})
+		if _, ok := attrs["sizes"]; !ok {
+			tok.Attr = append(tok.Attr,
+				xhtml.Attribute{Key: "sizes", Val: fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", img.width, img.width)})
+		}
+		changed = true
+	}
+
+	if !changed {
+		return nil, nil
+	}
+	return &tok, nil
+}
+
+// localPath returns the path, within the output, of the image with the
+// given source, within content written to the given directory, or the
+// empty string if it isn't one of ours.
+func (p *ImageProcessor) localPath(src string, dir string) string {
+
+	u, err := url.Parse(strings.TrimSpace(src))
+	if err != nil {
+		return ""
+	}
+
+	rel := ""
+
+	switch {
+	case u.Scheme != "" || u.Host != "":
+		// Absolute links must be beneath our prefix.
+		if !strings.HasPrefix(src, p.Prefix) {
+			return ""
+		}
+		rel = strings.TrimPrefix(src, p.Prefix)
+		if v, err := url.PathUnescape(strings.SplitN(rel, "?", 2)[0]); err == nil {
+			rel = v
+		}
+
+	case strings.HasPrefix(u.Path, "/"):
+		// Links relative to the root of the site, which may be
+		// beneath a path in the prefix.
+		root := "/"
+		if pu, err := url.Parse(p.Prefix); err == nil && pu.Path != "" {
+			root = pu.Path
+		}
+		if !strings.HasPrefix(u.Path, root) {
+			return ""
+		}
+		rel = strings.TrimPrefix(u.Path, root)
+
+	default:
+		// Relative links are relative to the directory the
+		// content is written to.
+		rel = path.Join(dir, u.Path)
+	}
+
+	rel = path.Clean("/" + rel)[1:]
+	if rel == "" {
+		return ""
+	}
+
+	switch strings.ToLower(path.Ext(rel)) {
+	case ".jpg", ".jpeg", ".png":
+		return rel
+	}
+	return ""
+}
+
+// process generates the variants of the given image, returning nil if
+// the image cannot be read.
+func (p *ImageProcessor) process(file string) (*processedImage, error) {
+
+	if p.images == nil {
+		p.images = make(map[string]*processedImage)
+	}
+	if img, ok := p.images[file]; ok {
+		return img, nil
+	}
+
+	// Missing and broken images are left alone, as they would be
+	// without processing.
+	data, err := fs.ReadFile(p.Source, file)
+	if err != nil {
+		p.images[file] = nil
+		return nil, nil
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		p.images[file] = nil
+		return nil, nil
+	}
+
+	img := &processedImage{width: cfg.Width, height: cfg.Height, variants: make(map[int]string)}
+	p.images[file] = img
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+	ext := path.Ext(file)
+
+	var decoded image.Image
+
+	for _, width := range p.Widths {
+		if width <= 0 || width >= cfg.Width {
+			continue
+		}
+
+		name := variantName(file, hash, width)
+		cached := filepath.Join(p.CachePath, fmt.Sprintf("%s-%d%s", hash, width, ext))
+
+		//
+		// Generate the variant, if it isn't cached.
+		//
+		if _, err := os.Stat(cached); err != nil {
+			if decoded == nil {
+				decoded, _, err = image.Decode(bytes.NewReader(data))
+				if err != nil {
+					return img, nil
+				}
+			}
+
+			err = p.writeVariant(cached, decoded, format, width)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		//
+		// Copy it into the output, unless it is already present.
+		//
+		dest := path.Join(path.Dir(file), name)
+		if !p.present(cached, dest) {
+			variant, err := os.ReadFile(cached)
+			if err != nil {
+				return nil, err
+			}
+			err = p.write(dest, variant)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		img.variants[width] = name
+		p.files = append(p.files, dest)
+	}
+
+	return img, nil
+}
+
+// variantName returns the name of the variant of the given image, with
+// the given width, where hash is the hash of the image.
+//
+// The name includes the start of the hash, so that the variants of an
+// image which changes have different names, and so are never mistaken
+// for those of the previous version.
+func variantName(file string, hash string, width int) string {
+	ext := path.Ext(file)
+	base := strings.TrimSuffix(path.Base(file), ext)
+	return fmt.Sprintf("%s-%dw-%s%s", base, width, hash[:8], ext)
+}
+
+// Files returns the variants which have been written, or were already
+// present, relative to the root of the output.
+func (p *ImageProcessor) Files() []string {
+	return p.files
+}
+
+// present returns true if the named variant is already present within
+// the output, with the same size as the cached variant.  This is only
+// known for outputs which are an IncrementalFS.
+//
+// Since the names of variants include the hash of their image a variant
+// with the same name, and size, is the same variant.
+func (p *ImageProcessor) present(cached string, name string) bool {
+
+	output, ok := p.Output.(IncrementalFS)
+	if !ok {
+		return false
+	}
+
+	ci, err := os.Stat(cached)
+	if err != nil {
+		return false
+	}
+	oi, err := output.Stat(name)
+	if err != nil {
+		return false
+	}
+	return ci.Size() == oi.Size()
+}
+
+// write writes the named variant into the output.
+func (p *ImageProcessor) write(name string, data []byte) error {
+
+	out, err := p.Output.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeVariant resizes the image to the given width, and writes it to
+// the given file.
+func (p *ImageProcessor) writeVariant(dest string, img image.Image, format string, width int) error {
+
+	b := img.Bounds()
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	resized := resize(img, width, height)
+
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file, so that a failure doesn't leave
+	// a broken variant in the cache.
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if format == "png" {
+		err = png.Encode(out, resized)
+	} else {
+		quality := p.Quality
+		if quality <= 0 || quality > 100 {
+			quality = DefaultImageQuality
+		}
+		err = jpeg.Encode(out, resized, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// resize scales an image down to the given size, averaging the source
+// pixels which cover each destination pixel.
+func resize(img image.Image, width int, height int) *image.RGBA {
+
+	// Convert the source to RGBA, which is quick for the common
+	// formats, so that we can read the pixels directly.
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := (y + 1) * sh / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := (x + 1) * sw / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					bl += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(bl / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// RewriteImages processes the images referenced by every entry within the
+// site, rewriting the content of each entry.
+//
+// Entries are written to the top of the output.
+func (e *Ephemeris) RewriteImages(p *ImageProcessor) error {
+
+	for i := range e.BlogEntries {
+		content, err := p.Rewrite(e.BlogEntries[i].Content, ".")
+		if err != nil {
+			return fmt.Errorf("failed to process the images of %s - %s", e.BlogEntries[i].Path, err.Error())
+		}
+		e.BlogEntries[i].Content = content
+	}
+
+	// The series hold copies of the entries.
+	e.SeriesIndex = buildSeriesIndex(e.BlogEntries, e.Prefix)
+	return nil
+}
@@ -0,0 +1,218 @@
+package ephemeris
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestImage writes an image of the given size.
+func writeTestImage(t *testing.T, file string, width int, height int) {
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+
+	os.MkdirAll(filepath.Dir(file), 0755)
+	out, err := os.Create(file)
+	if err != nil {
+		t.Fatalf("failed to create %s", file)
+	}
+	defer out.Close()
+
+	if strings.HasSuffix(file, ".png") {
+		err = png.Encode(out, img)
+	} else {
+		err = jpeg.Encode(out, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode %s", file)
+	}
+}
+
+// variant returns the path of the variant, with the given width, of the
+// given image within the output.
+func variant(output string, file string, width int) string {
+	data, _ := os.ReadFile(filepath.Join(output, filepath.FromSlash(file)))
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+	return path.Join(path.Dir(file), variantName(file, hash, width))
+}
+
+// Test rewriting images.
+func TestImageProcessor(t *testing.T) {
+
+	output := t.TempDir()
+	cache := t.TempDir()
+	writeTestImage(t, filepath.Join(output, "2020", "cat.jpg"), 200, 100)
+	writeTestImage(t, filepath.Join(output, "dog.png"), 100, 50)
+
+	p := &ImageProcessor{
+		Widths:    []int{150, 50, 400},
+		CachePath: cache,
+		Source:    os.DirFS(output),
+		Output:    DirOutput(output),
+		Prefix:    "https://example.com/blog/",
+	}
+
+	in := `<p>Hello <img src="2020/cat.jpg" alt="A cat"> and <img src="https://example.com/blog/dog.png" width="10">
+<img src="/blog/dog.png"> <img src="https://example.org/remote.jpg"> <img src="missing.jpg"></p>`
+
+	out, err := p.Rewrite(in, ".")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	cat50, cat150, dog50 := variant(output, "2020/cat.jpg", 50), variant(output, "2020/cat.jpg", 150), variant(output, "dog.png", 50)
+
+	expected := []string{
+		`<img src="2020/cat.jpg" alt="A cat" width="200" height="100" srcset="` + cat50 + ` 50w, ` + cat150 + ` 150w, 2020/cat.jpg 200w" sizes="(max-width: 200px) 100vw, 200px">`,
+		`<img src="https://example.com/blog/dog.png" width="10" srcset="https://example.com/blog/` + dog50 + ` 50w, https://example.com/blog/dog.png 100w" sizes="(max-width: 100px) 100vw, 100px">`,
+		`<img src="/blog/dog.png" width="100" height="50" srcset="/blog/` + dog50 + ` 50w, /blog/dog.png 100w" sizes="(max-width: 100px) 100vw, 100px">`,
+		`<img src="https://example.org/remote.jpg">`,
+		`<img src="missing.jpg">`,
+		`<p>Hello `,
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("output didn't contain %s:\n%s", e, out)
+		}
+	}
+
+	//
+	// The variants are present, and the right size.
+	//
+	for file, width := range map[string]int{cat50: 50, cat150: 150, dog50: 50} {
+		f, err := os.Open(filepath.Join(output, file))
+		if err != nil {
+			t.Fatalf("variant %s is missing", file)
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != width || cfg.Height != width/2 {
+			t.Errorf("variant %s had the wrong size %v %v", file, cfg, err)
+		}
+	}
+
+	// The variants are reported, in the order they were written.
+	files := []string{cat150, cat50, dog50}
+	if !reflect.DeepEqual(p.Files(), files) {
+		t.Errorf("unexpected variants %v", p.Files())
+	}
+
+	//
+	// Variants are cached between runs.
+	//
+	cached, _ := os.ReadDir(cache)
+	if len(cached) != 3 {
+		t.Fatalf("expected three cached variants, got %d", len(cached))
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, c := range cached {
+		os.Chtimes(filepath.Join(cache, c.Name()), old, old)
+	}
+	os.RemoveAll(filepath.Join(output, cat50))
+
+	p = &ImageProcessor{Widths: p.Widths, CachePath: cache, Source: p.Source, Output: p.Output, Prefix: p.Prefix}
+	again, err := p.Rewrite(in, ".")
+	if err != nil || again != out {
+		t.Errorf("unexpected output on the second run %v\n%s", err, again)
+	}
+	for _, c := range cached {
+		info, _ := os.Stat(filepath.Join(cache, c.Name()))
+		if !info.ModTime().Equal(old) {
+			t.Errorf("cached variant %s was regenerated", c.Name())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(output, cat50)); err != nil {
+		t.Errorf("variant wasn't restored from the cache")
+	}
+}
+
+// Test writing variants to an output which is not a directory.
+func TestImageProcessorMemory(t *testing.T) {
+
+	source := t.TempDir()
+	writeTestImage(t, filepath.Join(source, "cat.png"), 100, 50)
+
+	output := &MemoryOutput{}
+	p := &ImageProcessor{
+		Widths:    []int{50},
+		CachePath: t.TempDir(),
+		Source:    os.DirFS(source),
+		Output:    output,
+		Prefix:    "https://example.com/",
+	}
+
+	_, err := p.Rewrite(`<img src="cat.png">`, ".")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	data, err := fs.ReadFile(output, variant(source, "cat.png", 50))
+	if err != nil {
+		t.Fatalf("variant is missing %s", err.Error())
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width != 50 {
+		t.Errorf("variant had the wrong size %v %v", cfg, err)
+	}
+}
+
+// Test that relative images are resolved against the directory the
+// content is written to, and that changed images get new variants.
+func TestImageProcessorRelative(t *testing.T) {
+
+	output := t.TempDir()
+	writeTestImage(t, filepath.Join(output, "about", "me.png"), 100, 50)
+
+	p := &ImageProcessor{
+		Widths:    []int{50},
+		CachePath: t.TempDir(),
+		Source:    os.DirFS(output),
+		Output:    DirOutput(output),
+		Prefix:    "https://example.com/",
+	}
+
+	// A page written to about/index.html.
+	out, err := p.Rewrite(`<img src="me.png">`, "about")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	old := variant(output, "about/me.png", 50)
+	if !strings.Contains(out, `srcset="`+path.Base(old)+` 50w, me.png 100w"`) {
+		t.Errorf("unexpected output %s", out)
+	}
+
+	// The same image, at the top of the output, isn't found.
+	out, _ = p.Rewrite(`<img src="me.png">`, ".")
+	if out != `<img src="me.png">` {
+		t.Errorf("unexpected output %s", out)
+	}
+
+	// A different image, of the same size, has a new variant.
+	writeTestImage(t, filepath.Join(output, "about", "me.png"), 100, 60)
+	os.Chtimes(filepath.Join(output, "about", "me.png"), time.Now(), time.Now())
+	p = &ImageProcessor{Widths: p.Widths, CachePath: p.CachePath, Source: p.Source, Output: p.Output, Prefix: p.Prefix}
+	p.Rewrite(`<img src="me.png">`, "about")
+
+	if now := variant(output, "about/me.png", 50); now == old {
+		t.Errorf("the changed image has the same variant %s", now)
+	} else if _, err := os.Stat(filepath.Join(output, now)); err != nil {
+		t.Errorf("the new variant is missing")
+	}
+}
@@ -0,0 +1,177 @@
+package ephemeris
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// SummaryLength is the maximum length, in characters, of the summary of
+// an entry.
+const SummaryLength = 200
+
+// EntryMetadata holds the details of an entry which are shown when it is
+// linked to, from social networks, chat-clients, and search engines.
+type EntryMetadata struct {
+	// Title holds the title of the entry.
+	Title string
+
+	// Summary holds the start of the entry, as plain text.
+	Summary string
+
+	// URL is the canonical link to the entry.
+	URL string
+
+	// Image is the absolute link to the image shown for the entry,
+	// if it has an "Image:" header.
+	Image string
+
+	// Published is when the entry was published.
+	Published time.Time
+
+	// Modified is when the entry last changed, which is when the most
+	// recent comment was left upon it, if any.
+	Modified time.Time
+
+	// Tags holds the tags of the entry.
+	Tags []string
+}
+
+// Metadata returns the metadata describing the entry.
+func (b BlogEntry) Metadata() EntryMetadata {
+	return EntryMetadata{
+		Title:     b.Title,
+		Summary:   Summarize(b.Content, SummaryLength),
+		URL:       strings.ToLower(b.Link),
+		Image:     b.Image,
+		Published: b.Date,
+		Modified:  entryModified(b),
+		Tags:      b.Tags,
+	}
+}
+
+// JSONLD returns the metadata as a schema.org "BlogPosting", encoded as
+// JSON for use within a `<script type="application/ld+json">` tag.
+//
+// The characters "<", ">", and "&" are escaped, so the result cannot
+// close the script-tag it is contained within.
+func (m EntryMetadata) JSONLD() string {
+
+	type posting struct {
+		Context          string `json:"@context"`
+		Type             string `json:"@type"`
+		Headline         string `json:"headline"`
+		Description      string `json:"description,omitempty"`
+		URL              string `json:"url"`
+		MainEntityOfPage string `json:"mainEntityOfPage"`
+		Image            string `json:"image,omitempty"`
+		DatePublished    string `json:"datePublished,omitempty"`
+		DateModified     string `json:"dateModified,omitempty"`
+		Keywords         string `json:"keywords,omitempty"`
+	}
+
+	p := posting{
+		Context:          "https://schema.org",
+		Type:             "BlogPosting",
+		Headline:         m.Title,
+		Description:      m.Summary,
+		URL:              m.URL,
+		MainEntityOfPage: m.URL,
+		Image:            m.Image,
+		Keywords:         strings.Join(m.Tags, ", "),
+	}
+	if !m.Published.IsZero() {
+		p.DatePublished = m.Published.Format(time.RFC3339)
+	}
+	if !m.Modified.IsZero() {
+		p.DateModified = m.Modified.Format(time.RFC3339)
+	}
+
+	// Marshalling a struct of strings cannot fail.
+	out, _ := json.Marshal(p)
+	return string(out)
+}
+
+// inlineElements holds the elements which may appear within a word, such
+// as "<b>bold</b>ly", so which aren't treated as separating words.
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "cite": true, "code": true,
+	"em": true, "i": true, "mark": true, "q": true, "s": true,
+	"small": true, "span": true, "strong": true, "sub": true,
+	"sup": true, "u": true,
+}
+
+// Summarize returns the text of the given HTML, with the markup removed
+// and whitespace collapsed, truncated to at most the given number of
+// characters.
+//
+// Text is truncated at the end of a word, where possible, and has an
+// ellipsis appended when it is.
+func Summarize(content string, length int) string {
+
+	var text strings.Builder
+
+	tokens := xhtml.NewTokenizer(strings.NewReader(content))
+	skip := 0
+	for {
+		tt := tokens.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+
+		switch tt {
+		case xhtml.StartTagToken, xhtml.EndTagToken, xhtml.SelfClosingTagToken:
+			name, _ := tokens.TagName()
+			tag := string(name)
+			if tag == "script" || tag == "style" {
+				if tt == xhtml.StartTagToken {
+					skip++
+				} else if tt == xhtml.EndTagToken && skip > 0 {
+					skip--
+				}
+			}
+
+			// Words either side of other elements, such as
+			// paragraphs, are separate.
+			if !inlineElements[tag] {
+				text.WriteString(" ")
+			}
+		case xhtml.TextToken:
+			if skip == 0 {
+				text.Write(tokens.Text())
+			}
+		}
+	}
+
+	summary := strings.Join(strings.Fields(text.String()), " ")
+
+	runes := []rune(summary)
+	if len(runes) <= length {
+		return summary
+	}
+
+	cut := string(runes[:length])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// absoluteLink returns the given link, resolved relative to the prefix of
+// the blog, so that relative links such as "2020/cat.jpg" and "/cat.jpg"
+// become absolute.
+func absoluteLink(prefix string, link string) string {
+
+	base, err := url.Parse(prefix)
+	if err != nil || prefix == "" {
+		return link
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,137 @@
+package ephemeris
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the metadata of an entry with an image.
+func TestEntryMetadata(t *testing.T) {
+
+	site, err := New("", "", "https://example.com/blog/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	b, err := NewBlogEntry("_test/blog_entry/image.txt", site)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if b.Image != "https://example.com/blog/2005/cat.jpg" {
+		t.Errorf("unexpected image %s", b.Image)
+	}
+
+	m := b.Metadata()
+	if m.Title != "A post with a picture" {
+		t.Errorf("unexpected title %s", m.Title)
+	}
+	if m.Summary != "My cat & me." {
+		t.Errorf("unexpected summary %s", m.Summary)
+	}
+	if m.URL != "https://example.com/blog/a_post_with_a_picture.html" {
+		t.Errorf("unexpected URL %s", m.URL)
+	}
+	if !m.Published.Equal(b.Date) || !m.Modified.Equal(b.Date) {
+		t.Errorf("unexpected dates %s %s", m.Published, m.Modified)
+	}
+	if len(m.Tags) != 1 || m.Tags[0] != "cats" {
+		t.Errorf("unexpected tags %v", m.Tags)
+	}
+
+	// Comments update the modification time.
+	later := b.Date.Add(time.Hour)
+	b.CommentData = []BlogComment{{Date: later}}
+	if m = b.Metadata(); !m.Modified.Equal(later) {
+		t.Errorf("unexpected modification time %s", m.Modified)
+	}
+}
+
+// Test resolving links against the prefix.
+func TestAbsoluteLink(t *testing.T) {
+
+	tests := []struct {
+		prefix   string
+		link     string
+		expected string
+	}{
+		{"https://example.com/blog/", "cat.jpg", "https://example.com/blog/cat.jpg"},
+		{"https://example.com/blog/", "/cat.jpg", "https://example.com/cat.jpg"},
+		{"https://example.com/blog/", "https://cdn.example.net/cat.jpg", "https://cdn.example.net/cat.jpg"},
+		{"", "cat.jpg", "cat.jpg"},
+	}
+
+	for _, test := range tests {
+		if got := absoluteLink(test.prefix, test.link); got != test.expected {
+			t.Errorf("resolving %s against %s gave %s not %s", test.link, test.prefix, got, test.expected)
+		}
+	}
+}
+
+// Test the JSON-LD representation of the metadata.
+func TestJSONLD(t *testing.T) {
+
+	m := EntryMetadata{
+		Title:     "Escaping </script> tags",
+		Summary:   "A & B",
+		URL:       "https://example.com/escaping.html",
+		Published: time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC),
+		Tags:      []string{"go", "html"},
+	}
+
+	out := m.JSONLD()
+	if strings.Contains(out, "<") || strings.Contains(out, "&") {
+		t.Errorf("JSON-LD wasn't escaped: %s", out)
+	}
+
+	var got map[string]string
+	err := json.Unmarshal([]byte(out), &got)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %s", out, err.Error())
+	}
+
+	expected := map[string]string{
+		"@context":         "https://schema.org",
+		"@type":            "BlogPosting",
+		"headline":         m.Title,
+		"description":      m.Summary,
+		"url":              m.URL,
+		"mainEntityOfPage": m.URL,
+		"datePublished":    "2020-03-01T12:00:00Z",
+		"keywords":         "go, html",
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Errorf("%s was %q not %q", k, got[k], v)
+		}
+	}
+	for _, k := range []string{"image", "dateModified"} {
+		if _, ok := got[k]; ok {
+			t.Errorf("unexpected %s in %s", k, out)
+		}
+	}
+}
+
+// Test summarizing HTML content.
+func TestSummarize(t *testing.T) {
+
+	tests := []struct {
+		content  string
+		length   int
+		expected string
+	}{
+		{"<p>Hello,\n  <b>world</b>.</p>", 100, "Hello, world."},
+		{"<p>One</p><p>Two</p>", 100, "One Two"},
+		{"<style>p { color: red }</style><p>Text</p><script>alert(1)</script>", 100, "Text"},
+		{"<p>One two three four.</p>", 12, "One two…"},
+		{"<p>Onetwothreefour</p>", 5, "Onetw…"},
+		{"", 10, ""},
+	}
+
+	for _, test := range tests {
+		if got := Summarize(test.content, test.length); got != test.expected {
+			t.Errorf("summarizing %q gave %q not %q", test.content, got, test.expected)
+		}
+	}
+}
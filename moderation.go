@@ -0,0 +1,362 @@
+package ephemeris
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/headerfile"
+)
+
+// The actions which may be applied to a comment.
+const (
+	// ActionApprove moves a pending comment into the comment-directory.
+	ActionApprove = "approve"
+
+	// ActionDelete removes a pending comment.
+	ActionDelete = "delete"
+
+	// ActionSubscribe confirms that the submitter of a comment wants
+	// to be notified of later comments upon the same entry.
+	ActionSubscribe = "subscribe"
+
+	// ActionUnsubscribe stops notifying the submitter of a comment of
+	// later comments upon the same entry.
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// unsubscribeExpiry is how long links to unsubscribe remain valid, which
+// is longer than other links as they're kept along with notifications.
+const unsubscribeExpiry = 365 * 24 * time.Hour
+
+// pendingName matches the names of comment-files, "${link}.${ctime}".
+var pendingName = regexp.MustCompile(`^[a-z0-9_]+\.html\.[0-9]+$`)
+
+// Moderator holds comments in a pending-directory until they're approved,
+// and is a HTTP handler which approves or deletes them.
+//
+// Each pending comment may be moderated via links which are signed with
+// a secret, and which expire after a time, so the site-owner doesn't need
+// to log in to moderate comments.  The links which commenters follow to
+// subscribe to, and unsubscribe from, notifications are signed, and
+// handled, in the same way.
+type Moderator struct {
+	// Secret is used to sign the moderation links.
+	Secret string
+
+	// URL is the public URL of this handler, used to generate links.
+	URL string
+
+	// Expiry is how long links remain valid.
+	Expiry time.Duration
+
+	// Prefix is the URL-prefix of the blog.
+	Prefix string
+
+	// PendingPath holds comments awaiting moderation.
+	PendingPath string
+
+	// CommentsPath is the directory approved comments are moved to.
+	CommentsPath string
+
+	// Notifier is used to tell subscribers about approved comments,
+	// if it is non-nil.
+	Notifier *Notifier
+}
+
+// sign returns the signature of an action upon the named comment-file.
+func (m *Moderator) sign(action string, name string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(m.Secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d", action, name, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Link returns the signed link to apply the given action to the named
+// comment-file.
+//
+// Links expire after the moderator's Expiry, except for links to
+// unsubscribe, which remain valid for a year.
+func (m *Moderator) Link(action string, name string, now time.Time) string {
+	expiry := m.Expiry
+	if action == ActionUnsubscribe {
+		expiry = unsubscribeExpiry
+	}
+	expires := now.Add(expiry).Unix()
+
+	v := url.Values{}
+	v.Set("action", action)
+	v.Set("comment", name)
+	v.Set("expires", strconv.FormatInt(expires, 10))
+	v.Set("sig", m.sign(action, name, expires))
+
+	return m.URL + "?" + v.Encode()
+}
+
+// Verify checks that the signature upon a link is valid, and that the
+// link hasn't expired.
+func (m *Moderator) Verify(action string, name string, expires string, sig string, now time.Time) error {
+
+	if m.Secret == "" {
+		return fmt.Errorf("no moderation secret is configured")
+	}
+	switch action {
+	case ActionApprove, ActionDelete, ActionSubscribe, ActionUnsubscribe:
+	default:
+		return fmt.Errorf("unknown action %s", action)
+	}
+	if !pendingName.MatchString(name) {
+		return fmt.Errorf("invalid comment %s", name)
+	}
+
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry %s", expires)
+	}
+
+	expected := m.sign(action, name, exp)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	if now.Unix() > exp {
+		return fmt.Errorf("the link has expired")
+	}
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+//
+// Following a link shows a confirmation form, and submitting that form
+// performs the action.  This two-step process ensures that links aren't
+// acted upon by mail-scanners, or browsers, which prefetch them.
+func (m *Moderator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	action := r.FormValue("action")
+	name := r.FormValue("comment")
+	expires := r.FormValue("expires")
+	sig := r.FormValue("sig")
+
+	err := m.Verify(action, name, expires, sig, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Subscriptions may be changed whether the comment has been
+	// approved or not, but only pending comments may be moderated.
+	file := filepath.Join(m.PendingPath, name)
+	if action == ActionSubscribe || action == ActionUnsubscribe {
+		if published := filepath.Join(m.CommentsPath, name); fileExists(published) {
+			file = published
+		}
+	}
+	if !fileExists(file) {
+		if action == ActionApprove || action == ActionDelete {
+			http.Error(w, "The comment has already been moderated", http.StatusNotFound)
+		} else {
+			http.Error(w, "The comment no longer exists", http.StatusNotFound)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	switch r.Method {
+	case http.MethodGet:
+		question, label := "Approve this comment?", "Approve"
+		switch action {
+		case ActionDelete:
+			question, label = "Delete this comment?", "Delete"
+		case ActionSubscribe:
+			question, label = "Email you when others comment upon this entry?", "Subscribe"
+		case ActionUnsubscribe:
+			question, label = "Stop emailing you when others comment upon this entry?", "Unsubscribe"
+		}
+
+		body, _ := headerfile.New(file).Body()
+		fmt.Fprintf(w, `<html>
+ <head>
+  <title>%s</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+ </head>
+ <body>
+  <h2>%s</h2>
+  <pre>%s</pre>
+  <form method="POST">
+   <input type="hidden" name="action" value="%s" />
+   <input type="hidden" name="comment" value="%s" />
+   <input type="hidden" name="expires" value="%s" />
+   <input type="hidden" name="sig" value="%s" />
+   <input type="submit" value="%s" />
+  </form>
+ </body>
+</html>
+`, label, question, html.EscapeString(body), html.EscapeString(action), html.EscapeString(name),
+			html.EscapeString(expires), html.EscapeString(sig), label)
+		return
+
+	case http.MethodPost:
+		// handled below
+
+	default:
+		http.Error(w, "Only GET and POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch action {
+	case ActionDelete:
+		err = os.Remove(file)
+		if err != nil {
+			http.Error(w, "Failed to delete the comment", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "<html><body><h2>The comment has been deleted.</h2></body></html>\n")
+		return
+
+	case ActionSubscribe:
+		err = setNotify(file, true)
+		if err != nil {
+			log.Printf("failed to subscribe %s: %s", name, err.Error())
+			http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "<html><body><h2>You will be emailed when others comment upon this entry.</h2></body></html>\n")
+		return
+
+	case ActionUnsubscribe:
+		err = m.unsubscribe(file)
+		if err != nil {
+			log.Printf("failed to unsubscribe %s: %s", name, err.Error())
+			http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "<html><body><h2>You will no longer be emailed when others comment upon this entry.</h2></body></html>\n")
+		return
+	}
+
+	published, err := m.approve(name)
+	if err != nil {
+		log.Printf("failed to approve %s: %s", name, err.Error())
+		http.Error(w, "Failed to approve the comment", http.StatusInternalServerError)
+		return
+	}
+
+	//
+	// Now the comment is published, tell the subscribers.
+	//
+	if m.Notifier != nil {
+		comment, err := newCommentFromFile(published, m.Prefix)
+		if err == nil {
+			go func() {
+				err := m.Notifier.NotifySubscribers(comment)
+				if err != nil {
+					log.Printf("failed to send notifications for %s: %s", published, err.Error())
+				}
+			}()
+		}
+	}
+
+	fmt.Fprintf(w, "<html><body><h2>The comment has been approved.</h2><p>It will be included the next time the blog is rebuilt.</p></body></html>\n")
+}
+
+// approve moves the named comment from the pending-directory to the
+// comment-directory, returning its new path.
+func (m *Moderator) approve(name string) (string, error) {
+
+	src := filepath.Join(m.PendingPath, name)
+	dst := filepath.Join(m.CommentsPath, name)
+
+	if _, err := os.Stat(dst); err == nil {
+		return "", fmt.Errorf("%s already exists", dst)
+	}
+
+	// Renaming is atomic, but fails across filesystems.
+	if os.Rename(src, dst) == nil {
+		return dst, nil
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(dst, data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return dst, os.Remove(src)
+}
+
+// unsubscribe stops notifying the submitter of the given comment-file of
+// new comments upon the same entry, by removing the "Notify:" header from
+// each of their comments upon it.
+func (m *Moderator) unsubscribe(file string) error {
+
+	headers, err := headerfile.New(file).Headers()
+	if err != nil {
+		return err
+	}
+	mail := headers["mail"]
+
+	base := filepath.Base(file)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+
+	for _, dir := range []string{m.CommentsPath, m.PendingPath} {
+		files, err := entryComments(dir, name)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for _, path := range files {
+			h, err := headerfile.New(path).Headers()
+			if err != nil || !strings.EqualFold(h["mail"], mail) || h["notify"] == "" {
+				continue
+			}
+			err = setNotify(path, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fileExists returns true if the given file exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// newCommentFromFile creates the description of a new comment, for
+// notifications, from a comment-file.
+func newCommentFromFile(file string, prefix string) (NewComment, error) {
+
+	reader := headerfile.New(file)
+	headers, err := reader.Headers()
+	if err != nil {
+		return NewComment{}, err
+	}
+	body, _ := reader.Body()
+
+	base := filepath.Base(file)
+
+	return NewComment{
+		File:   file,
+		Entry:  prefix + strings.TrimSuffix(base, filepath.Ext(base)),
+		Author: headers["name"],
+		Mail:   headers["mail"],
+		Link:   headers["link"],
+		IP:     headers["ip-address"],
+		Body:   strings.TrimSpace(body),
+	}, nil
+}
@@ -0,0 +1,332 @@
+package ephemeris
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestModerator returns a moderator using temporary directories.
+func newTestModerator(t *testing.T) *Moderator {
+	return &Moderator{
+		Secret:       "secret",
+		URL:          "https://example.com/moderate",
+		Expiry:       time.Hour,
+		Prefix:       "https://example.com/",
+		PendingPath:  t.TempDir(),
+		CommentsPath: t.TempDir(),
+	}
+}
+
+// moderate follows the given link, with the given method.
+func moderate(m *Moderator, method string, link string) *httptest.ResponseRecorder {
+	u, _ := url.Parse(link)
+
+	var req *http.Request
+	if method == "POST" {
+		req = httptest.NewRequest(method, "/moderate", strings.NewReader(u.RawQuery))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, "/moderate?"+u.RawQuery, nil)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	return rec
+}
+
+// Test that links are signed, and expire.
+func TestModerationLinks(t *testing.T) {
+
+	m := newTestModerator(t)
+	now := time.Now()
+	name := "my_first_post.html.1234"
+
+	link := m.Link(ActionApprove, name, now)
+	if !strings.HasPrefix(link, "https://example.com/moderate?") {
+		t.Fatalf("unexpected link %s", link)
+	}
+
+	u, _ := url.Parse(link)
+	q := u.Query()
+
+	err := m.Verify(q.Get("action"), q.Get("comment"), q.Get("expires"), q.Get("sig"), now)
+	if err != nil {
+		t.Errorf("unexpected error verifying link: %s", err.Error())
+	}
+
+	type TestCase struct {
+		action  string
+		name    string
+		expires string
+		sig     string
+		now     time.Time
+	}
+
+	bad := []TestCase{
+		// a different action
+		{ActionDelete, name, q.Get("expires"), q.Get("sig"), now},
+		// a different comment
+		{ActionApprove, "other.html.1234", q.Get("expires"), q.Get("sig"), now},
+		// an extended expiry
+		{ActionApprove, name, q.Get("expires") + "0", q.Get("sig"), now},
+		// a bogus signature
+		{ActionApprove, name, q.Get("expires"), "abcdef", now},
+		// too late
+		{ActionApprove, name, q.Get("expires"), q.Get("sig"), now.Add(2 * time.Hour)},
+		// path traversal
+		{ActionApprove, "../my_first_post.html.1234", q.Get("expires"), q.Get("sig"), now},
+	}
+
+	for _, tst := range bad {
+		err := m.Verify(tst.action, tst.name, tst.expires, tst.sig, tst.now)
+		if err == nil {
+			t.Errorf("expected an error verifying %v", tst)
+		}
+	}
+
+	// A different secret results in a different signature.
+	other := &Moderator{Secret: "other", Expiry: time.Hour}
+	err = other.Verify(q.Get("action"), q.Get("comment"), q.Get("expires"), q.Get("sig"), now)
+	if err == nil {
+		t.Errorf("expected an error verifying with a different secret")
+	}
+}
+
+// Test approving a pending comment.
+func TestModerationApprove(t *testing.T) {
+
+	m := newTestModerator(t)
+	name := "my_first_post.html.1234"
+	os.WriteFile(filepath.Join(m.PendingPath, name), []byte("Name: Steve\nMail: steve@example.com\n\nHello <b>world</b>\n"), 0644)
+
+	link := m.Link(ActionApprove, name, time.Now())
+
+	// Following the link only shows a confirmation.
+	rec := moderate(m, "GET", link)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Approve this comment?") || !strings.Contains(rec.Body.String(), "Hello &lt;b&gt;world&lt;/b&gt;") {
+		t.Errorf("unexpected confirmation %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(m.PendingPath, name)); err != nil {
+		t.Fatalf("the comment was moved before confirmation")
+	}
+
+	rec = moderate(m, "POST", link)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(m.PendingPath, name)); !os.IsNotExist(err) {
+		t.Errorf("the comment is still pending")
+	}
+	if _, err := os.Stat(filepath.Join(m.CommentsPath, name)); err != nil {
+		t.Errorf("the comment wasn't published")
+	}
+
+	// Reusing the link fails.
+	rec = moderate(m, "POST", link)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unexpected status reusing link %d", rec.Code)
+	}
+}
+
+// Test deleting a pending comment.
+func TestModerationDelete(t *testing.T) {
+
+	m := newTestModerator(t)
+	name := "my_first_post.html.1234"
+	os.WriteFile(filepath.Join(m.PendingPath, name), []byte("Name: Spam\nMail: spam@example.com\n\nBuy now\n"), 0644)
+
+	// An approval link can't be used to delete.
+	approve := m.Link(ActionApprove, name, time.Now())
+	rec := moderate(m, "POST", strings.Replace(approve, "action=approve", "action=delete", 1))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("unexpected status %d", rec.Code)
+	}
+
+	rec = moderate(m, "POST", m.Link(ActionDelete, name, time.Now()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(m.PendingPath, name)); !os.IsNotExist(err) {
+		t.Errorf("the comment wasn't deleted")
+	}
+	files, _ := os.ReadDir(m.CommentsPath)
+	if len(files) != 0 {
+		t.Errorf("the comment was published")
+	}
+}
+
+// Test subscriptions are confirmed, and cancelled, via signed links.
+func TestModerationSubscribe(t *testing.T) {
+
+	server := newFakeSMTP(t)
+	defer server.listener.Close()
+
+	m := newTestModerator(t)
+	n := &Notifier{Host: "127.0.0.1", Port: server.port(), From: "blog@example.com", Moderator: m}
+
+	// Two comments, one published and one pending.
+	published := filepath.Join(m.CommentsPath, "my_first_post.html.1")
+	pending := filepath.Join(m.PendingPath, "my_first_post.html.2")
+	os.WriteFile(published, []byte("Name: Steve\nMail: steve@example.com\n\nHello\n"), 0644)
+	os.WriteFile(pending, []byte("Name: Steve\nMail: Steve@example.com\nNotify: yes\n\nAgain\n"), 0644)
+
+	err := n.ConfirmSubscription(NewComment{File: published, Entry: "https://example.com/my_first_post.html", Mail: "steve@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error confirming: %s", err.Error())
+	}
+
+	msg := server.message("steve@example.com")
+	i := strings.Index(msg, "https://example.com/moderate?")
+	if i < 0 {
+		t.Fatalf("confirmation contained no link: %s", msg)
+	}
+	link := strings.Fields(msg[i:])[0]
+
+	// Following the link only shows a confirmation.
+	rec := moderate(m, "GET", link)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Subscribe") {
+		t.Errorf("unexpected confirmation %d %s", rec.Code, rec.Body.String())
+	}
+	if subs, _ := Subscribers(filepath.Join(m.CommentsPath, "my_first_post.html.3")); len(subs) != 0 {
+		t.Fatalf("subscribed before confirmation: %v", subs)
+	}
+
+	rec = moderate(m, "POST", link)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	if subs, _ := Subscribers(filepath.Join(m.CommentsPath, "my_first_post.html.3")); len(subs) != 1 {
+		t.Fatalf("expected a subscriber, got %v", subs)
+	}
+	if data, _ := os.ReadFile(published); !strings.HasSuffix(string(data), "Notify: yes\n\nHello\n") {
+		t.Errorf("unexpected comment-file %s", data)
+	}
+
+	// Unsubscribing removes the subscription from every comment.
+	rec = moderate(m, "POST", m.Link(ActionUnsubscribe, filepath.Base(published), time.Now()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	for _, file := range []string{published, pending} {
+		if data, _ := os.ReadFile(file); strings.Contains(string(data), "Notify:") {
+			t.Errorf("subscription wasn't removed %s", data)
+		}
+	}
+
+	// Links to other actions can't be reused to subscribe.
+	link = strings.Replace(m.Link(ActionApprove, filepath.Base(published), time.Now()), "action=approve", "action=subscribe", 1)
+	if rec := moderate(m, "POST", link); rec.Code != http.StatusForbidden {
+		t.Errorf("unexpected status for a forged link %d", rec.Code)
+	}
+}
+
+// Test the receiver holds comments for moderation, and sends the links.
+func TestCommentReceiverModeration(t *testing.T) {
+
+	server := newFakeSMTP(t)
+	defer server.listener.Close()
+
+	m := newTestModerator(t)
+	c := &CommentReceiver{
+		Prefix:       "https://example.com/",
+		CommentsPath: m.CommentsPath,
+		Moderator:    m,
+		Site:         testCommentSite(),
+		Notifier: &Notifier{
+			Host:  "127.0.0.1",
+			Port:  server.port(),
+			From:  "blog@example.com",
+			Owner: "owner@example.com",
+		},
+	}
+
+	rec := postComment(c, url.Values{
+		"name": {"Steve"},
+		"mail": {"steve@example.com"},
+		"id":   {"my_first_post.html"},
+		"body": {"Hello"},
+	})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "once it has been approved") {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+
+	published, _ := os.ReadDir(m.CommentsPath)
+	pending, _ := os.ReadDir(m.PendingPath)
+	if len(published) != 0 || len(pending) != 1 {
+		t.Fatalf("expected a single pending comment, got %v %v", published, pending)
+	}
+
+	// Notifications are sent in the background.
+	for i := 0; i < 100 && server.count() == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	msg := server.message("owner@example.com")
+	if !strings.Contains(msg, "Approve: https://example.com/moderate?action=approve&comment="+pending[0].Name()) ||
+		!strings.Contains(msg, "Delete:  https://example.com/moderate?action=delete&comment="+pending[0].Name()) {
+		t.Errorf("notification didn't contain moderation links: %s", msg)
+	}
+}
+
+// Test the webmention receiver holds new mentions for moderation too.
+func TestWebmentionReceiverModeration(t *testing.T) {
+
+	server := newFakeSMTP(t)
+	defer server.listener.Close()
+
+	target := "https://example.com/my_first_post.html"
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>A reply</title></head><body><a href="` + target + `">Steve</a></body></html>`))
+	}))
+	defer source.Close()
+
+	m := newTestModerator(t)
+	receiver := &WebmentionReceiver{
+		Client:       source.Client(),
+		Prefix:       "https://example.com/",
+		CommentsPath: m.CommentsPath,
+		Moderator:    m,
+		Site:         testCommentSite(),
+		Notifier: &Notifier{
+			Host:  "127.0.0.1",
+			Port:  server.port(),
+			From:  "blog@example.com",
+			Owner: "owner@example.com",
+		},
+		private: true,
+	}
+
+	// Resending a pending mention doesn't duplicate it.
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/webmention", strings.NewReader(url.Values{"source": {source.URL}, "target": {target}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		receiver.ServeHTTP(rec, req)
+		receiver.pending.Wait()
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	published, _ := os.ReadDir(m.CommentsPath)
+	pending, _ := os.ReadDir(m.PendingPath)
+	if len(published) != 0 || len(pending) != 1 {
+		t.Fatalf("expected a single pending mention, got %v %v", published, pending)
+	}
+
+	// Notifications are sent in the background.
+	for i := 0; i < 100 && server.count() == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	msg := server.message("owner@example.com")
+	if !strings.Contains(msg, "Approve: https://example.com/moderate?action=approve&comment="+pending[0].Name()) {
+		t.Errorf("notification didn't contain moderation links: %s", msg)
+	}
+}
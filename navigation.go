@@ -0,0 +1,106 @@
+package ephemeris
+
+import (
+	"sort"
+)
+
+// EntryNavigation holds the entries published either side of an entry,
+// to allow readers to move between them.
+type EntryNavigation struct {
+	// Prev is the entry published before this one, if any.
+	Prev *BlogEntry
+
+	// Next is the entry published after this one, if any.
+	Next *BlogEntry
+
+	// Tags holds the adjacent entries within each of the entry's
+	// tags, in the same order as the tags.
+	Tags []TagNavigation
+}
+
+// TagNavigation holds the entries either side of an entry, amongst those
+// with the given tag.
+type TagNavigation struct {
+	// Tag is the name of the tag.
+	Tag string
+
+	// Prev is the previous entry with this tag, if any.
+	Prev *BlogEntry
+
+	// Next is the next entry with this tag, if any.
+	Next *BlogEntry
+}
+
+// Navigation returns the adjacent entries of every entry in the site,
+// keyed by the path of each entry.
+//
+// Entries are ordered by date, and entries published at the same time
+// are ordered by their path, so the result is stable.
+func (e *Ephemeris) Navigation() map[string]EntryNavigation {
+
+	// Sort a copy of the entries, oldest first.
+	entries := make([]BlogEntry, len(e.BlogEntries))
+	copy(entries, e.BlogEntries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Date.Equal(entries[j].Date) {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Date.Before(entries[j].Date)
+	})
+
+	result := make(map[string]EntryNavigation)
+
+	// The entries with each tag, oldest first, and the position of
+	// each entry within them.
+	tagged := make(map[string][]int)
+	position := make(map[string]map[int]int)
+
+	for i, entry := range entries {
+		nav := EntryNavigation{}
+		if i > 0 {
+			nav.Prev = &entries[i-1]
+		}
+		if i < len(entries)-1 {
+			nav.Next = &entries[i+1]
+		}
+		result[entry.Path] = nav
+
+		for _, tag := range entry.Tags {
+			if position[tag] == nil {
+				position[tag] = make(map[int]int)
+			}
+			if _, ok := position[tag][i]; ok {
+				continue
+			}
+			position[tag][i] = len(tagged[tag])
+			tagged[tag] = append(tagged[tag], i)
+		}
+	}
+
+	for i, entry := range entries {
+		nav := result[entry.Path]
+
+		for _, tag := range entry.Tags {
+
+			// Tags are sorted, so duplicates are adjacent.
+			if len(nav.Tags) > 0 && nav.Tags[len(nav.Tags)-1].Tag == tag {
+				continue
+			}
+
+			list := tagged[tag]
+			pos := position[tag][i]
+
+			t := TagNavigation{Tag: tag}
+			if pos > 0 {
+				t.Prev = &entries[list[pos-1]]
+			}
+			if pos < len(list)-1 {
+				t.Next = &entries[list[pos+1]]
+			}
+			nav.Tags = append(nav.Tags, t)
+		}
+		result[entry.Path] = nav
+	}
+
+	return result
+}
@@ -0,0 +1,64 @@
+package ephemeris
+
+import (
+	"testing"
+	"time"
+)
+
+// Test the navigation between entries.
+func TestNavigation(t *testing.T) {
+
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(title string, days int, tags ...string) BlogEntry {
+		return BlogEntry{Title: title, Path: title + ".txt", Date: date.AddDate(0, 0, days), Tags: tags}
+	}
+
+	// Deliberately out of order, and with two entries at the
+	// same time.
+	site := &Ephemeris{BlogEntries: []BlogEntry{
+		entry("d", 3, "go"),
+		entry("a", 1, "go"),
+		entry("c", 2),
+		entry("b", 2, "go", "misc"),
+	}}
+
+	nav := site.Navigation()
+
+	title := func(e *BlogEntry) string {
+		if e == nil {
+			return ""
+		}
+		return e.Title
+	}
+
+	type TestCase struct {
+		path string
+		prev string
+		next string
+	}
+
+	tests := []TestCase{
+		{"a.txt", "", "b"},
+		{"b.txt", "a", "c"},
+		{"c.txt", "b", "d"},
+		{"d.txt", "c", ""},
+	}
+	for _, tst := range tests {
+		n := nav[tst.path]
+		if title(n.Prev) != tst.prev || title(n.Next) != tst.next {
+			t.Errorf("%s had prev/next %s/%s not %s/%s", tst.path, title(n.Prev), title(n.Next), tst.prev, tst.next)
+		}
+	}
+
+	// Within the "go" tag "c" is skipped.
+	b := nav["b.txt"]
+	if len(b.Tags) != 2 || b.Tags[0].Tag != "go" || title(b.Tags[0].Prev) != "a" || title(b.Tags[0].Next) != "d" {
+		t.Errorf("unexpected tag-navigation %v", b.Tags)
+	}
+	if b.Tags[1].Tag != "misc" || b.Tags[1].Prev != nil || b.Tags[1].Next != nil {
+		t.Errorf("unexpected tag-navigation %v", b.Tags)
+	}
+	if len(nav["c.txt"].Tags) != 0 {
+		t.Errorf("untagged entry had tag-navigation")
+	}
+}
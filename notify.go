@@ -0,0 +1,317 @@
+package ephemeris
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/headerfile"
+)
+
+// Notifier sends emails about new comments, via SMTP.
+//
+// The owner of the site is sent a copy of every comment, and earlier
+// commenters upon the same entry who asked to be notified are told
+// that there has been a new comment.
+//
+// Commenters must confirm that they want to be notified, via a link sent
+// to their address, and each notification contains a link to stop them.
+// These links are signed by the moderator, without which nobody is ever
+// notified.
+type Notifier struct {
+	// Host is the SMTP server to deliver mail via.
+	Host string
+
+	// Port is the port of the SMTP server, defaulting to 25.
+	Port int
+
+	// Username and Password are used to authenticate to the SMTP
+	// server, if the username is non-empty.
+	Username string
+	Password string
+
+	// From is the sender of each email.
+	From string
+
+	// Owner is the address of the site-owner, if this is empty the
+	// owner isn't notified.
+	Owner string
+
+	// Moderator signs the links to confirm, and cancel, subscriptions.
+	// If this is nil commenters can't subscribe to later comments.
+	Moderator *Moderator
+}
+
+// NewComment describes a comment which has just been received.
+type NewComment struct {
+	// File is the comment-file the comment was written to.
+	File string
+
+	// Entry is the link to the entry the comment was left upon.
+	Entry string
+
+	// Author, Mail, Link, IP, and Body are the details of the
+	// comment.
+	Author string
+	Mail   string
+	Link   string
+	IP     string
+	Body   string
+
+	// Approve and Reject are the links to moderate the comment, if
+	// it is awaiting moderation.
+	Approve string
+	Reject  string
+}
+
+// Notify sends the notifications for a new comment, to both the owner
+// and the subscribers.
+//
+// Every message is attempted, and the first error (if any) is returned.
+func (n *Notifier) Notify(comment NewComment) error {
+
+	first := n.NotifyOwner(comment)
+
+	err := n.NotifySubscribers(comment)
+	if first == nil {
+		first = err
+	}
+	return first
+}
+
+// NotifyOwner sends the site-owner all the details of a new comment,
+// including the links to moderate it, if present.
+func (n *Notifier) NotifyOwner(comment NewComment) error {
+
+	if n.Owner != "" {
+		var body strings.Builder
+		fmt.Fprintf(&body, "A new comment has been posted upon %s\n\n", comment.Entry)
+		fmt.Fprintf(&body, "Name: %s\n", comment.Author)
+		fmt.Fprintf(&body, "Mail: %s\n", comment.Mail)
+		if comment.Link != "" {
+			fmt.Fprintf(&body, "Link: %s\n", comment.Link)
+		}
+		if comment.IP != "" {
+			fmt.Fprintf(&body, "IP-Address: %s\n", comment.IP)
+		}
+		fmt.Fprintf(&body, "File: %s\n\n%s\n", comment.File, comment.Body)
+		if comment.Approve != "" {
+			fmt.Fprintf(&body, "\nApprove: %s\n", comment.Approve)
+		}
+		if comment.Reject != "" {
+			fmt.Fprintf(&body, "Delete:  %s\n", comment.Reject)
+		}
+
+		return n.send(n.Owner, "New comment upon "+comment.Entry, body.String())
+	}
+	return nil
+}
+
+// ConfirmSubscription sends the author of a new comment, who asked to be
+// notified of later comments upon the same entry, a link to confirm it.
+//
+// Their comment-file only records that they want to be notified, with a
+// "Notify: yes" header, once they've followed the link.
+func (n *Notifier) ConfirmSubscription(comment NewComment) error {
+
+	if n.Moderator == nil || comment.Mail == "" {
+		return nil
+	}
+
+	link := n.Moderator.Link(ActionSubscribe, filepath.Base(comment.File), time.Now())
+
+	body := fmt.Sprintf("You asked to be emailed when others comment upon %s\n\nTo confirm this visit:\n\n  %s\n\nIf you didn't ask for this you may ignore this email.\n",
+		comment.Entry, link)
+
+	return n.send(comment.Mail, "Confirm notifications of comments upon "+comment.Entry, body)
+}
+
+// NotifySubscribers tells earlier commenters, who asked to be notified,
+// about a new comment.  They only receive the public details, along with
+// a link to stop being notified.
+//
+// Every message is attempted, and the first error (if any) is returned.
+func (n *Notifier) NotifySubscribers(comment NewComment) error {
+
+	// Without links to unsubscribe nobody is notified.
+	if n.Moderator == nil {
+		return nil
+	}
+
+	subscribers, err := subscriptions(comment.File)
+	if err != nil {
+		return err
+	}
+
+	var first error
+
+	for _, sub := range subscribers {
+		if strings.EqualFold(sub.Mail, comment.Mail) || strings.EqualFold(sub.Mail, n.Owner) {
+			continue
+		}
+
+		link := n.Moderator.Link(ActionUnsubscribe, filepath.Base(sub.File), time.Now())
+
+		body := fmt.Sprintf("%s has commented upon %s\n\n%s\n\nYou are receiving this because you asked to be notified of new comments upon this entry.  To stop these emails visit:\n\n  %s\n",
+			comment.Author, comment.Entry, comment.Body, link)
+
+		err := n.send(sub.Mail, "New comment upon "+comment.Entry, body)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Subscribers returns the email addresses of the submitters of earlier
+// comments, upon the same entry as the given comment-file, who asked to
+// be notified of new comments.
+//
+// Submitters opt in via a "Notify: yes" header in their comment-file.
+func Subscribers(file string) ([]string, error) {
+
+	subscribers, err := subscriptions(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, sub := range subscribers {
+		result = append(result, sub.Mail)
+	}
+	return result, nil
+}
+
+// subscription is a submitter who asked to be notified of new comments,
+// along with the comment-file in which they asked.
+type subscription struct {
+	Mail string
+	File string
+}
+
+// subscriptions returns the subscriptions to the entry of the given
+// comment-file, from the earlier comments upon it, sorted by address.
+func subscriptions(file string) ([]subscription, error) {
+
+	base := filepath.Base(file)
+	files, err := entryComments(filepath.Dir(file), strings.TrimSuffix(base, filepath.Ext(base)))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var result []subscription
+
+	for _, path := range files {
+		if filepath.Base(path) == base {
+			continue
+		}
+
+		headers, err := headerfile.New(path).Headers()
+		if err != nil {
+			continue
+		}
+
+		mail := strings.ToLower(headers["mail"])
+		if strings.ToLower(headers["notify"]) != "yes" || mail == "" || seen[mail] {
+			continue
+		}
+		seen[mail] = true
+		result = append(result, subscription{Mail: headers["mail"], File: path})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Mail < result[j].Mail
+	})
+	return result, nil
+}
+
+// entryComments returns the paths of the comment-files, within the given
+// directory, upon the named entry.
+func entryComments(dir string, name string) ([]string, error) {
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), name+".") {
+			continue
+		}
+
+		// Only numeric suffixes are comments.
+		if _, err := strconv.ParseInt(strings.TrimPrefix(f.Name(), name+"."), 10, 64); err != nil {
+			continue
+		}
+		result = append(result, filepath.Join(dir, f.Name()))
+	}
+	return result, nil
+}
+
+// setNotify records whether the submitter of the given comment-file wants
+// to be notified of new comments, via its "Notify:" header.
+func setNotify(file string, notify bool) error {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	headers, body := string(data), ""
+	if i := strings.Index(headers, "\n\n"); i >= 0 {
+		headers, body = headers[:i+1], headers[i+1:]
+	}
+
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(headers, "\n") {
+		name := strings.SplitN(line, ":", 2)[0]
+		if strings.EqualFold(strings.TrimSpace(name), "notify") {
+			continue
+		}
+		out.WriteString(line)
+	}
+	if notify {
+		out.WriteString("Notify: yes\n")
+	}
+	out.WriteString(body)
+
+	return os.WriteFile(file, []byte(out.String()), 0644)
+}
+
+// send delivers a single email.
+func (n *Notifier) send(to string, subject string, body string) error {
+
+	port := n.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	// Newlines in headers would allow injecting more headers.
+	clean := strings.NewReplacer("\r", "", "\n", " ")
+
+	msg := "From: " + clean.Replace(n.From) + "\r\n" +
+		"To: " + clean.Replace(to) + "\r\n" +
+		"Subject: " + clean.Replace(subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+
+	return smtp.SendMail(addr, auth, n.From, []string{to}, []byte(msg))
+}
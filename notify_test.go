@@ -0,0 +1,215 @@
+package ephemeris
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a minimal SMTP server, which records the messages it
+// receives.
+type fakeSMTP struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	messages map[string]string
+}
+
+// newFakeSMTP launches a fake SMTP server upon a random local port.
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+
+	s := &fakeSMTP{listener: l, messages: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+// handle processes a single SMTP session.
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(msg string) { conn.Write([]byte(msg + "\r\n")) }
+
+	reply("220 localhost fake")
+
+	to := ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to = strings.Trim(strings.TrimSpace(line)[8:], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 Go ahead")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.mutex.Lock()
+			s.messages[to] = msg.String()
+			s.mutex.Unlock()
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// port returns the port the server is listening upon.
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// message returns the message received for the given recipient.
+func (s *fakeSMTP) message(to string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.messages[to]
+}
+
+// count returns the number of messages received.
+func (s *fakeSMTP) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.messages)
+}
+
+// Test the subscribers to an entry are found.
+func TestSubscribers(t *testing.T) {
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"entry.html.1": "Name: A\nMail: a@example.com\nNotify: yes\n\nOne\n",
+		"entry.html.2": "Name: B\nMail: b@example.com\n\nTwo\n",
+		"entry.html.3": "Name: A\nMail: A@example.com\nNotify: yes\n\nThree\n",
+		"other.html.4": "Name: C\nMail: c@example.com\nNotify: yes\n\nFour\n",
+		"entry.html.5": "Name: D\nMail: d@example.com\nNotify: yes\n\nFive\n",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	subs, err := Subscribers(filepath.Join(dir, "entry.html.5"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if len(subs) != 1 || !strings.EqualFold(subs[0], "a@example.com") {
+		t.Errorf("unexpected subscribers: %v", subs)
+	}
+}
+
+// Test notifications are sent to the owner, and subscribers.
+func TestNotify(t *testing.T) {
+
+	server := newFakeSMTP(t)
+	defer server.listener.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "entry.html.1"), []byte("Name: A\nMail: a@example.com\nNotify: yes\n\nOne\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "entry.html.2"), []byte("Name: B\nMail: b@example.com\nNotify: yes\n\nTwo\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "entry.html.3"), []byte("Name: B\nMail: b@example.com\n\nThree\n"), 0644)
+
+	n := &Notifier{
+		Host:  "127.0.0.1",
+		Port:  server.port(),
+		From:  "blog@example.com",
+		Owner: "owner@example.com",
+	}
+
+	// Without a moderator to sign links subscribers aren't notified.
+	err := n.NotifySubscribers(NewComment{File: filepath.Join(dir, "entry.html.3")})
+	if err != nil || server.count() != 0 {
+		t.Fatalf("unexpected notifications without a moderator %d", server.count())
+	}
+
+	n.Moderator = newTestModerator(t)
+	err = n.Notify(NewComment{
+		File:   filepath.Join(dir, "entry.html.3"),
+		Entry:  "https://example.com/entry.html",
+		Author: "B",
+		Mail:   "b@example.com",
+		IP:     "10.0.0.1",
+		Body:   "Three",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error notifying: %s", err.Error())
+	}
+
+	// The owner and A are notified, B isn't told about their own comment.
+	if server.count() != 2 {
+		t.Fatalf("expected two messages, got %d", server.count())
+	}
+
+	owner := server.message("owner@example.com")
+	if !strings.Contains(owner, "Subject: New comment upon https://example.com/entry.html") || !strings.Contains(owner, "10.0.0.1") {
+		t.Errorf("unexpected message to the owner: %s", owner)
+	}
+
+	sub := server.message("a@example.com")
+	if !strings.Contains(sub, "Three") || strings.Contains(sub, "10.0.0.1") || strings.Contains(sub, "b@example.com") {
+		t.Errorf("unexpected message to subscriber: %s", sub)
+	}
+	if !strings.Contains(sub, "https://example.com/moderate?action=unsubscribe&comment=entry.html.1&") {
+		t.Errorf("message to subscriber had no link to unsubscribe: %s", sub)
+	}
+}
+
+// Test failures to deliver are reported.
+func TestNotifyFailure(t *testing.T) {
+
+	// Find a port nothing is listening upon.
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	n := &Notifier{Host: "127.0.0.1", Port: port, From: "blog@example.com", Owner: "owner@example.com"}
+
+	done := make(chan error)
+	go func() {
+		done <- n.Notify(NewComment{File: filepath.Join(t.TempDir(), "entry.html.1")})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected an error sending to a closed port")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout waiting for failure")
+	}
+}
@@ -0,0 +1,360 @@
+package ephemeris
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputFS is the destination to which a generated site is written.
+//
+// Names are relative to the root of the site, and use forward slashes.
+// Implementations must be safe for concurrent use, since the parts of a
+// site are written concurrently.
+type OutputFS interface {
+	// Create creates, or truncates, the named file, creating any
+	// directories which are required.
+	Create(name string) (io.WriteCloser, error)
+
+	// Symlink creates a symlink with the given name, pointing at the
+	// target, which is relative to the directory of the link.
+	Symlink(target string, name string) error
+}
+
+// IncrementalFS is an OutputFS which keeps the files of previous builds,
+// such as a directory.
+//
+// The existing files may be examined, and given the modification time
+// of their source, so that files which haven't changed needn't be
+// written again.
+type IncrementalFS interface {
+	OutputFS
+	fs.StatFS
+
+	// Chtimes sets the modification time of the named file.
+	Chtimes(name string, mtime time.Time) error
+}
+
+// dirOutput is an OutputFS which writes beneath a local directory.
+type dirOutput struct {
+	dir string
+}
+
+// DirOutput returns an OutputFS which writes beneath the given directory.
+//
+// The result is also an IncrementalFS.
+func DirOutput(dir string) OutputFS {
+	return &dirOutput{dir: dir}
+}
+
+// path returns the local path of the named file.
+func (d *dirOutput) path(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(d.dir, filepath.FromSlash(name)), nil
+}
+
+// Create creates the named file beneath the directory.
+func (d *dirOutput) Create(name string) (io.WriteCloser, error) {
+
+	file, err := d.path("create", name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(file)
+}
+
+// Symlink creates the named symlink beneath the directory.
+func (d *dirOutput) Symlink(target string, name string) error {
+
+	file, err := d.path("symlink", name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(target), file)
+}
+
+// Open opens the named file beneath the directory.
+func (d *dirOutput) Open(name string) (fs.File, error) {
+
+	file, err := d.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(file)
+}
+
+// Stat returns the details of the named file beneath the directory.
+func (d *dirOutput) Stat(name string) (fs.FileInfo, error) {
+
+	file, err := d.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(file)
+}
+
+// Chtimes sets the modification time of the named file beneath the
+// directory.
+func (d *dirOutput) Chtimes(name string, mtime time.Time) error {
+
+	file, err := d.path("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(file, mtime, mtime)
+}
+
+// MemoryOutput is an OutputFS which holds a site in memory, for example
+// to test a theme, or to serve a preview of a site without writing it to
+// disk.
+//
+// It is also an fs.FS, so the site may be read back, or served via
+// http.FS, and an IncrementalFS.  The zero value is an empty site.
+type MemoryOutput struct {
+	mu    sync.RWMutex
+	files map[string]*memoryEntry
+}
+
+// memoryEntry holds a single file, or symlink, within a MemoryOutput.
+type memoryEntry struct {
+	data    []byte
+	link    string
+	modTime time.Time
+}
+
+// memoryWriter collects the contents of a file, which is added to the
+// output when it is closed, so that partial files are never visible.
+type memoryWriter struct {
+	bytes.Buffer
+	output *MemoryOutput
+	name   string
+}
+
+// Close adds the file to the output.
+func (w *memoryWriter) Close() error {
+	w.output.add(w.name, &memoryEntry{data: w.Bytes(), modTime: time.Now()})
+	return nil
+}
+
+// add adds an entry, replacing any existing entry of the same name.
+func (m *MemoryOutput) add(name string, e *memoryEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.files == nil {
+		m.files = make(map[string]*memoryEntry)
+	}
+	m.files[name] = e
+}
+
+// Create creates the named file.
+func (m *MemoryOutput) Create(name string) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	return &memoryWriter{output: m, name: name}, nil
+}
+
+// Symlink creates the named symlink, which fails if the name exists.
+func (m *MemoryOutput) Symlink(target string, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrExist}
+	}
+	if m.files == nil {
+		m.files = make(map[string]*memoryEntry)
+	}
+	m.files[name] = &memoryEntry{link: target, modTime: time.Now()}
+	return nil
+}
+
+// Chtimes sets the modification time of the named file.
+func (m *MemoryOutput) Chtimes(name string, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	e.modTime = mtime
+	return nil
+}
+
+// resolve follows the symlinks, if any, at the given name, returning the
+// name they point to, and the entry there, if there is one.
+func (m *MemoryOutput) resolve(name string) (string, *memoryEntry) {
+
+	// Links may point to links, but not forever.
+	for i := 0; i < 16; i++ {
+		e, ok := m.files[name]
+		if !ok || e.link == "" {
+			return name, e
+		}
+		name = path.Join(path.Dir(name), e.link)
+	}
+	return name, nil
+}
+
+// Open opens the named file, or directory, following any symlinks.
+func (m *MemoryOutput) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	target, e := m.resolve(name)
+	if e != nil {
+		info := &memoryInfo{name: path.Base(name), size: int64(len(e.data)), modTime: e.modTime}
+		return &memoryFile{Reader: bytes.NewReader(e.data), info: info}, nil
+	}
+
+	entries := m.readDir(target)
+	if entries == nil && target != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memoryDir{info: &memoryInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+}
+
+// Stat returns the details of the named file, or directory.
+func (m *MemoryOutput) Stat(name string) (fs.FileInfo, error) {
+	f, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// readDir returns the entries within the named directory, sorted by
+// name, or nil if there are none.  Directories are implied by the files
+// beneath them.
+func (m *MemoryOutput) readDir(dir string) []fs.DirEntry {
+
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+
+	children := make(map[string]*memoryInfo)
+	for name, e := range m.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+
+		if i := strings.Index(rest, "/"); i >= 0 {
+			children[rest[:i]] = &memoryInfo{name: rest[:i], dir: true}
+			continue
+		}
+		children[rest] = &memoryInfo{name: rest, size: int64(len(e.data)), link: e.link != "", modTime: e.modTime}
+	}
+
+	var entries []fs.DirEntry
+	for _, info := range children {
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// memoryInfo describes a file, or directory, within a MemoryOutput.  It
+// is both an fs.FileInfo and an fs.DirEntry.
+type memoryInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	link    bool
+	modTime time.Time
+}
+
+func (i *memoryInfo) Name() string               { return i.name }
+func (i *memoryInfo) Size() int64                { return i.size }
+func (i *memoryInfo) ModTime() time.Time         { return i.modTime }
+func (i *memoryInfo) IsDir() bool                { return i.dir }
+func (i *memoryInfo) Sys() interface{}           { return nil }
+func (i *memoryInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *memoryInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// Mode returns the mode of the file.
+func (i *memoryInfo) Mode() fs.FileMode {
+	switch {
+	case i.dir:
+		return fs.ModeDir | 0755
+	case i.link:
+		return fs.ModeSymlink | 0777
+	}
+	return 0644
+}
+
+// memoryFile is an open file within a MemoryOutput.
+type memoryFile struct {
+	*bytes.Reader
+	info *memoryInfo
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memoryFile) Close() error               { return nil }
+
+// memoryDir is an open directory within a MemoryOutput.
+type memoryDir struct {
+	info    *memoryInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memoryDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memoryDir) Close() error               { return nil }
+
+// Read fails, since directories have no contents.
+func (d *memoryDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir returns the next n entries of the directory, or all those
+// remaining if n <= 0.
+func (d *memoryDir) ReadDir(n int) ([]fs.DirEntry, error) {
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
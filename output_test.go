@@ -0,0 +1,201 @@
+package ephemeris
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// writeFiles writes some files, and a symlink, to the given output.
+func writeFiles(t *testing.T, output OutputFS) {
+
+	for name, content := range map[string]string{
+		"index.html":         "index",
+		"tags/go/index.html": "go",
+		"Entry.html":         "stale",
+		"entry.html":         "entry",
+	} {
+		w, err := output.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", name, err.Error())
+		}
+		w.Write([]byte(content))
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("failed to close %s: %s", name, err.Error())
+		}
+	}
+
+	err := output.Symlink("entry.html", "Post.html")
+	if err != nil {
+		t.Fatalf("failed to create symlink: %s", err.Error())
+	}
+
+	if _, err := output.Create("../escape.html"); err == nil {
+		t.Errorf("expected an error creating a file outside the output")
+	}
+}
+
+// Test writing to a directory.
+func TestDirOutput(t *testing.T) {
+
+	dir := t.TempDir()
+	output := DirOutput(dir)
+	writeFiles(t, output)
+
+	data, err := os.ReadFile(filepath.Join(dir, "tags", "go", "index.html"))
+	if err != nil || string(data) != "go" {
+		t.Errorf("unexpected contents %s %v", data, err)
+	}
+	if target, _ := os.Readlink(filepath.Join(dir, "Post.html")); target != "entry.html" {
+		t.Errorf("unexpected symlink %s", target)
+	}
+
+	// Directories keep their files between builds.
+	existing, ok := output.(IncrementalFS)
+	if !ok {
+		t.Fatalf("directories should be incremental")
+	}
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	existing.Chtimes("index.html", when)
+	info, err := existing.Stat("index.html")
+	if err != nil || !info.ModTime().Equal(when) || info.Size() != 5 {
+		t.Errorf("unexpected details %v %v", info, err)
+	}
+}
+
+// Test writing to memory.
+func TestMemoryOutput(t *testing.T) {
+
+	output := &MemoryOutput{}
+	writeFiles(t, output)
+
+	// Symlinks are followed, and can't replace files.
+	data, err := fs.ReadFile(output, "Post.html")
+	if err != nil || string(data) != "entry" {
+		t.Errorf("unexpected contents %s %v", data, err)
+	}
+	if err := output.Symlink("entry.html", "Entry.html"); err == nil {
+		t.Errorf("expected an error replacing a file with a symlink")
+	}
+
+	// It is a valid filesystem.
+	err = fstest.TestFS(output, "index.html", "tags/go/index.html", "Post.html")
+	if err != nil {
+		t.Errorf("invalid filesystem: %s", err.Error())
+	}
+
+	entries, err := fs.ReadDir(output, ".")
+	if err != nil || len(entries) != 5 || entries[4].Name() != "tags" || !entries[4].IsDir() {
+		t.Errorf("unexpected entries %v %v", entries, err)
+	}
+
+	// Files may be replaced.
+	w, _ := output.Create("index.html")
+	w.Write([]byte("updated"))
+	if data, _ := fs.ReadFile(output, "index.html"); string(data) != "index" {
+		t.Errorf("file was updated before it was closed")
+	}
+	w.Close()
+	if data, _ := fs.ReadFile(output, "index.html"); string(data) != "updated" {
+		t.Errorf("file wasn't updated, %s", data)
+	}
+
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	output.Chtimes("index.html", when)
+	info, err := output.Stat("index.html")
+	if err != nil || !info.ModTime().Equal(when) {
+		t.Errorf("unexpected details %v %v", info, err)
+	}
+	if _, err := output.Stat("missing.html"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+// Test writing archives.
+func TestArchive(t *testing.T) {
+
+	for _, name := range []string{"site.tar", "site.tar.gz", "SITE.TGZ", "site.zip"} {
+
+		var buf bytes.Buffer
+		archive, err := NewArchive(&buf, name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", name, err.Error())
+		}
+
+		writeFiles(t, archive)
+
+		// Files can't be written twice.
+		if err := archive.Symlink("entry.html", "Post.html"); err == nil {
+			t.Errorf("%s: expected an error writing a file twice", name)
+		}
+
+		err = archive.Close()
+		if err != nil {
+			t.Fatalf("failed to close %s: %s", name, err.Error())
+		}
+
+		files := make(map[string]string)
+		links := make(map[string]string)
+
+		if name == "site.zip" {
+			r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("failed to read %s: %s", name, err.Error())
+			}
+			for _, f := range r.File {
+				in, _ := f.Open()
+				data, _ := io.ReadAll(in)
+				in.Close()
+				if f.Mode()&fs.ModeSymlink != 0 {
+					links[f.Name] = string(data)
+				} else {
+					files[f.Name] = string(data)
+				}
+			}
+		} else {
+			var in io.Reader = &buf
+			if name != "site.tar" {
+				in, err = gzip.NewReader(in)
+				if err != nil {
+					t.Fatalf("failed to decompress %s: %s", name, err.Error())
+				}
+			}
+			r := tar.NewReader(in)
+			for {
+				header, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read %s: %s", name, err.Error())
+				}
+				if header.Typeflag == tar.TypeSymlink {
+					links[header.Name] = header.Linkname
+					continue
+				}
+				data, _ := io.ReadAll(r)
+				files[header.Name] = string(data)
+			}
+		}
+
+		if len(files) != 4 || files["tags/go/index.html"] != "go" || files["entry.html"] != "entry" {
+			t.Errorf("%s: unexpected files %v", name, files)
+		}
+		if len(links) != 1 || links["Post.html"] != "entry.html" {
+			t.Errorf("%s: unexpected links %v", name, links)
+		}
+	}
+
+	if _, err := NewArchive(io.Discard, "site.rar"); err == nil || IsArchive("site.rar") || IsArchive(".zip") {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,165 @@
+package ephemeris
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/github_flavored_markdown"
+	"github.com/skx/headerfile"
+)
+
+// Page holds a standalone page, such as an "about" page, which is not
+// a blog-post.
+//
+// Pages use the same header-format as blog-posts, but they are not
+// included in the index, the tags, the archive, or the feeds.
+type Page struct {
+	// Title holds the page-title.
+	Title string
+
+	// Path holds the path to the source-file, on-disk.
+	Path string
+
+	// Content contains the page-body.
+	Content string
+
+	// Output is the path the page is written to, relative to the
+	// output-directory.
+	Output string
+
+	// Link is the absolute link to the page.
+	Link string
+
+	// Date is when the page was last updated, if it has a "Date:"
+	// header.
+	Date time.Time
+}
+
+// reservedPaths holds the names of the files, and directories, at the
+// top of the output which are generated, and so cannot be pages.
+var reservedPaths = map[string]bool{
+	"index":         true,
+	"index.html":    true,
+	"index.rss":     true,
+	"comments.rss":  true,
+	"sitemap.xml":   true,
+	"robots.txt":    true,
+	"tags":          true,
+	"archive":       true,
+	"comments":      true,
+	SeriesDirectory: true,
+	AvatarDirectory: true,
+}
+
+// NewPage creates a new page from the contents of the given file, which
+// must be beneath the given root-directory.
+//
+// The page is written to a directory named after the file, relative to
+// the root, so `about.txt` becomes `about/index.html` and is linked to
+// as `${prefix}about/`.  A file named `index.txt`, within a sub-directory,
+// is written to the index of that directory.
+func NewPage(path string, root string, prefix string) (Page, error) {
+
+	var result Page
+
+	reader := headerfile.New(path)
+
+	headers, err := reader.Headers()
+	if err != nil {
+		return result, err
+	}
+
+	// errors can't happen here, because if they were present
+	// they would have happened in the header-read.
+	body, _ := reader.Body()
+
+	for key, val := range headers {
+
+		switch key {
+		case "date":
+			t, err := time.Parse("02/01/2006 15:04", val)
+			if err != nil {
+				return result, err
+			}
+			result.Date = t
+		case "title", "subject":
+			result.Title = val
+		case "format":
+			if val == "markdown" {
+				body = string(github_flavored_markdown.Markdown([]byte(body)))
+			} else {
+				return result, fmt.Errorf("unknown page-format %s", val)
+			}
+		default:
+			return result, fmt.Errorf("unknown header-key %s in file %s", key, path)
+		}
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return result, err
+	}
+	rel = filepath.ToSlash(strings.TrimSuffix(rel, ".txt"))
+
+	// The directory the page is written to.
+	dir := strings.ToLower(strings.TrimSuffix(rel, "/index"))
+
+	// Pages cannot replace the parts of the blog which are generated.
+	if top := strings.SplitN(dir, "/", 2)[0]; reservedPaths[top] {
+		return result, fmt.Errorf("a page cannot replace the generated %s", top)
+	}
+
+	result.Path = path
+	result.Content = body
+	result.Output = filepath.Join(filepath.FromSlash(dir), "index.html")
+	result.Link = prefix + dir + "/"
+
+	return result, nil
+}
+
+// LoadPages returns all the pages beneath the given directory, searched
+// recursively for files matching the pattern "*.txt".
+//
+// The pages are sorted by their output-path.
+func LoadPages(directory string, prefix string) ([]Page, error) {
+
+	var pages []Page
+
+	err := filepath.Walk(directory,
+		func(path string, info os.FileInfo, err error) error {
+
+			// Error?  Then we're done.
+			if err != nil {
+				return err
+			}
+
+			// Ignore non-text files.
+			if !strings.HasSuffix(path, ".txt") {
+				return nil
+			}
+
+			page, err := NewPage(path, directory, prefix)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s - %s", path, err.Error())
+			}
+			pages = append(pages, page)
+			return nil
+		})
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Output < pages[j].Output
+	})
+
+	// Two pages mustn't be written to the same place.
+	for i := 1; i < len(pages); i++ {
+		if pages[i].Output == pages[i-1].Output {
+			return pages, fmt.Errorf("%s and %s are both written to %s", pages[i-1].Path, pages[i].Path, pages[i].Output)
+		}
+	}
+
+	return pages, err
+}
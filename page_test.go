@@ -0,0 +1,82 @@
+package ephemeris
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test loading a directory of pages.
+func TestLoadPages(t *testing.T) {
+
+	pages, err := LoadPages("_test/page", "https://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	type TestCase struct {
+		title  string
+		output string
+		link   string
+	}
+
+	expected := []TestCase{
+		{"About Me", "about/index.html", "https://example.com/about/"},
+		{"Ephemeris", "projects/ephemeris/index.html", "https://example.com/projects/ephemeris/"},
+		{"Projects", "projects/index.html", "https://example.com/projects/"},
+	}
+
+	if len(pages) != len(expected) {
+		t.Fatalf("expected %d pages, got %d", len(expected), len(pages))
+	}
+
+	for i, tst := range expected {
+		p := pages[i]
+		if p.Title != tst.title || p.Output != filepath.FromSlash(tst.output) || p.Link != tst.link {
+			t.Errorf("unexpected page %s %s %s", p.Title, p.Output, p.Link)
+		}
+	}
+
+	if !strings.Contains(pages[0].Content, "<strong>software</strong>") {
+		t.Errorf("markdown wasn't rendered: %s", pages[0].Content)
+	}
+	if pages[2].Date.Year() != 2020 {
+		t.Errorf("unexpected date %s", pages[2].Date)
+	}
+}
+
+// Test bogus pages are rejected.
+func TestBogusPages(t *testing.T) {
+
+	tests := map[string]string{
+		"index.txt":       "Title: Index\n\nHello\n",
+		"Tags.txt":        "Title: Tags\n\nHello\n",
+		"archive/x.txt":   "Title: Archive\n\nHello\n",
+		"series.txt":      "Title: Series\n\nHello\n",
+		"avatars.txt":     "Title: Avatars\n\nHello\n",
+		"sitemap.xml.txt": "Title: Sitemap\n\nHello\n",
+		"index.rss.txt":   "Title: Feed\n\nHello\n",
+		"header.txt":      "Title: Bogus\nTags: foo\n\nHello\n",
+		"format.txt":      "Title: Bogus\nFormat: rst\n\nHello\n",
+		"date.txt":        "Title: Bogus\nDate: yesterday\n\nHello\n",
+		"dupe.txt":        "Title: One\n\nHello\n",
+		"dupe/index.txt":  "Title: Two\n\nHello\n",
+	}
+
+	for name, content := range tests {
+		dir := t.TempDir()
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+
+		// The duplicate needs both files.
+		if name == "dupe/index.txt" {
+			os.WriteFile(filepath.Join(dir, "dupe.txt"), []byte(tests["dupe.txt"]), 0644)
+		}
+
+		_, err := LoadPages(dir, "https://example.com/")
+		if err == nil && name != "dupe.txt" {
+			t.Errorf("expected an error loading %s", name)
+		}
+	}
+}
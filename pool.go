@@ -0,0 +1,62 @@
+package ephemeris
+
+import (
+	"context"
+	"sync"
+)
+
+// forEach calls the given task for each index from zero to n-1, running
+// no more than the given number of tasks at once.
+//
+// Tasks are started in the order of their indexes, and if one fails no
+// more are started.  The error returned is that of the failed task with
+// the lowest index, since every task before it was started, and so it
+// doesn't depend upon the order in which the tasks happened to finish.
+//
+// If the context is cancelled no more tasks are started, and its error
+// is returned.
+func forEach(ctx context.Context, jobs int, n int, task func(i int) error) error {
+
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > n {
+		jobs = n
+	}
+
+	failed, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, n)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = task(i)
+				if errs[i] != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n && failed.Err() == nil; i++ {
+		select {
+		case indexes <- i:
+		case <-failed.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,98 @@
+package ephemeris
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test running tasks with a bounded number of workers.
+func TestForEach(t *testing.T) {
+
+	var mu sync.Mutex
+	running, most := 0, 0
+	seen := make(map[int]bool)
+
+	err := forEach(context.Background(), 3, 20, func(i int) error {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		seen[i] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if len(seen) != 20 {
+		t.Errorf("expected every task to run, ran %d", len(seen))
+	}
+	if most > 3 {
+		t.Errorf("ran %d tasks at once", most)
+	}
+
+	// No tasks is fine.
+	if err := forEach(context.Background(), 3, 0, nil); err != nil {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+}
+
+// Test that failures are reported consistently.
+func TestForEachFailure(t *testing.T) {
+
+	for attempt := 0; attempt < 20; attempt++ {
+
+		var mu sync.Mutex
+		started := 0
+
+		err := forEach(context.Background(), 4, 100, func(i int) error {
+			mu.Lock()
+			started++
+			mu.Unlock()
+
+			// The later failure is quicker, but the earlier
+			// one is reported.
+			switch i {
+			case 5:
+				time.Sleep(5 * time.Millisecond)
+				return fmt.Errorf("task %d failed", i)
+			case 6:
+				return fmt.Errorf("task %d failed", i)
+			}
+			return nil
+		})
+
+		if err == nil || err.Error() != "task 5 failed" {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if started == 100 {
+			t.Errorf("tasks were started after one failed")
+		}
+	}
+}
+
+// Test that no tasks are started once the context is cancelled.
+func TestForEachCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := forEach(ctx, 2, 10, func(i int) error {
+		t.Errorf("task %d was started", i)
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+}
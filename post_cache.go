@@ -0,0 +1,215 @@
+package ephemeris
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shurcooL/github_flavored_markdown"
+	"github.com/skx/headerfile"
+)
+
+// postCacheDir is the subdirectory of the cache-directory which holds the
+// cached posts, so that nothing else within it is ever pruned.
+const postCacheDir = "posts"
+
+// postCacheTemp is the pattern of the temporary files the cache writes
+// posts to, before they're renamed into place.
+const postCacheTemp = ".tmp-*"
+
+// postCacheTempAge is the age after which temporary files are considered
+// to have been abandoned, by builds which were interrupted, and pruned.
+const postCacheTempAge = time.Hour
+
+// postCacheName matches the names of the cached posts, "${sha256}.json".
+var postCacheName = regexp.MustCompile(`^[0-9a-f]{64}\.json$`)
+
+// postCacheVersion is hashed along with the contents of each post, so
+// that cached posts are ignored if the way posts are parsed changes.
+const postCacheVersion = "1"
+
+// postCache caches the headers, and the rendered body, of posts within a
+// directory, keyed by a hash of the contents of each post.  Posts which
+// haven't changed are then not rendered again.
+//
+// A nil cache is valid, and caches nothing.
+type postCache struct {
+	dir string
+
+	// used records the keys of the posts which have been parsed, so
+	// that the entries of other posts may be removed.
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+// parsedPost is a parsed post, as it is stored within the cache.
+type parsedPost struct {
+	// Headers holds the headers of the post.
+	Headers map[string]string
+
+	// Body holds the body of the post, which has been converted to
+	// HTML if it is Markdown.
+	Body string
+}
+
+// parse returns the headers, and the body, of the given post.
+//
+// If the post has a "Format: markdown" header then the body is converted
+// to HTML, unless it is cached.
+func (c *postCache) parse(path string) (map[string]string, string, error) {
+
+	if c == nil {
+		post, err := parsePost(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return post.Headers, post.Body, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	key := fmt.Sprintf("%x", sha256.Sum256(append([]byte(postCacheVersion+"\n"), data...)))
+
+	c.mu.Lock()
+	if c.used == nil {
+		c.used = make(map[string]bool)
+	}
+	c.used[key] = true
+	c.mu.Unlock()
+
+	// Cached?
+	file := filepath.Join(c.dir, key+".json")
+	if cached, err := os.ReadFile(file); err == nil {
+		var post parsedPost
+		if json.Unmarshal(cached, &post) == nil && post.Headers != nil {
+			return post.Headers, post.Body, nil
+		}
+	}
+
+	post, err := parsePost(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Failing to cache the post isn't fatal, it'll be rendered
+	// again next time.
+	c.store(file, post)
+
+	return post.Headers, post.Body, nil
+}
+
+// store writes a post to the cache, via a temporary file so that the
+// cache never holds a partial post.
+func (c *postCache) store(file string, post *parsedPost) error {
+
+	data, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, postCacheTemp)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// prune removes the cached posts which haven't been parsed, such as
+// those which have since been edited, or removed, along with temporary
+// files which have been abandoned.
+//
+// Only files named as cached posts, or temporary files, are removed.
+func (c *postCache) prune() error {
+
+	if c == nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range entries {
+		if e.IsDir() || !c.stale(e) {
+			continue
+		}
+		err = os.Remove(filepath.Join(c.dir, e.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// stale returns true if the given file within the cache should be pruned.
+func (c *postCache) stale(e fs.DirEntry) bool {
+
+	if postCacheName.MatchString(e.Name()) {
+		return !c.used[strings.TrimSuffix(e.Name(), ".json")]
+	}
+
+	// Temporary files may belong to a build which is still running,
+	// so they're only removed once they're old.
+	if matched, _ := filepath.Match(postCacheTemp, e.Name()); matched {
+		info, err := e.Info()
+		return err == nil && time.Since(info.ModTime()) > postCacheTempAge
+	}
+	return false
+}
+
+// parsePost reads the headers, and the body, of the given post, and
+// converts the body to HTML if it is Markdown.
+func parsePost(path string) (*parsedPost, error) {
+
+	// Create a helper to read the entry.
+	reader := headerfile.New(path)
+
+	// Read the headers from the post
+	headers, err := reader.Headers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the body from the post
+	//
+	// errors can't happen here, because if they were present
+	// they would have happened in the header-read.
+	body, _ := reader.Body()
+
+	if headers["format"] == "markdown" {
+		body = string(github_flavored_markdown.Markdown([]byte(body)))
+	}
+
+	return &parsedPost{Headers: headers, Body: body}, nil
+}
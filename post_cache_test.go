@@ -0,0 +1,117 @@
+package ephemeris
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test caching parsed posts.
+func TestPostCache(t *testing.T) {
+
+	posts := t.TempDir()
+	cache := filepath.Join(t.TempDir(), "cache")
+
+	post := filepath.Join(posts, "post.txt")
+	os.WriteFile(post, []byte("Title: Post\nDate: 01/02/2020 12:00\nFormat: markdown\n\nHello *world*\n"), 0644)
+	os.WriteFile(filepath.Join(posts, "plain.txt"), []byte("Title: Plain\nDate: 01/02/2020 12:00\n\nHello\n"), 0644)
+
+	// load loads the site, and returns the content of the post.
+	load := func() string {
+		site, err := NewWithOptions(posts, "", "https://example.com/", Options{CachePath: cache})
+		if err != nil {
+			t.Fatalf("unexpected error %s", err.Error())
+		}
+		for _, e := range site.Entries() {
+			if e.Path == post {
+				return e.Content
+			}
+		}
+		t.Fatalf("post is missing")
+		return ""
+	}
+
+	// cached returns the cached posts.
+	dir := filepath.Join(cache, postCacheDir)
+	cached := func() []string {
+		entries, _ := os.ReadDir(dir)
+		var names []string
+		for _, e := range entries {
+			if postCacheName.MatchString(e.Name()) {
+				names = append(names, e.Name())
+			}
+		}
+		return names
+	}
+
+	if content := load(); !strings.Contains(content, "<em>world</em>") {
+		t.Fatalf("post wasn't rendered %s", content)
+	}
+	names := cached()
+	if len(names) != 2 {
+		t.Fatalf("expected two cached posts, got %v", names)
+	}
+
+	// Cached posts aren't rendered again, which we can tell by
+	// replacing the rendered body of each.
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+		data, _ := os.ReadFile(file)
+		data = []byte(strings.Replace(string(data), "world", "cache", 1))
+		os.WriteFile(file, data, 0644)
+	}
+	if content := load(); !strings.Contains(content, "<em>cache</em>") {
+		t.Errorf("post wasn't loaded from the cache %s", content)
+	}
+
+	// Broken cached posts are rendered again.
+	for _, name := range names {
+		os.WriteFile(filepath.Join(dir, name), []byte("{broken"), 0644)
+	}
+	if content := load(); !strings.Contains(content, "<em>world</em>") {
+		t.Errorf("broken cache wasn't ignored %s", content)
+	}
+
+	// Other files within the cache are left alone, except for
+	// abandoned temporary files.
+	old := time.Now().Add(-2 * postCacheTempAge)
+	for _, name := range []string{filepath.Join(cache, "other.json"), filepath.Join(dir, "notes.json"), filepath.Join(dir, ".tmp-new"), filepath.Join(dir, ".tmp-old")} {
+		os.WriteFile(name, []byte("{}"), 0644)
+	}
+	os.Chtimes(filepath.Join(dir, ".tmp-old"), old, old)
+
+	// Editing a post renders it again, and removes its old entry.
+	os.WriteFile(post, []byte("Title: Post\nDate: 01/02/2020 12:00\nFormat: markdown\n\nHello **again**\n"), 0644)
+	if content := load(); !strings.Contains(content, "<strong>again</strong>") {
+		t.Errorf("edited post wasn't rendered %s", content)
+	}
+	if len(cached()) != 2 {
+		t.Errorf("stale entries weren't removed %v", cached())
+	}
+	for _, name := range []string{filepath.Join(cache, "other.json"), filepath.Join(dir, "notes.json"), filepath.Join(dir, ".tmp-new")} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("expected %s to be left alone", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".tmp-old")); err == nil {
+		t.Errorf("expected the abandoned temporary file to be removed")
+	}
+}
+
+// Test that invalid posts are reported, even when cached.
+func TestPostCacheInvalid(t *testing.T) {
+
+	posts := t.TempDir()
+	cache := t.TempDir()
+
+	os.WriteFile(filepath.Join(posts, "post.txt"), []byte("Title: Post\nDate: 01/02/2020 12:00\nFormat: textile\n\nHello\n"), 0644)
+
+	for i := 0; i < 2; i++ {
+		_, err := NewWithOptions(posts, "", "https://example.com/", Options{CachePath: cache})
+		if err == nil || !strings.Contains(err.Error(), "unknown entry-format") {
+			t.Errorf("expected an error, got %v", err)
+		}
+	}
+}
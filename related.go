@@ -0,0 +1,225 @@
+package ephemeris
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// htmlTag matches the markup within the content of an entry, which we
+// ignore when comparing entries.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// wordRegexp matches the words within the content of an entry.
+var wordRegexp = regexp.MustCompile(`[a-z0-9]+`)
+
+// stopWords are common words which say nothing about the topic of an
+// entry.
+var stopWords = map[string]bool{
+	"and": true, "are": true, "but": true, "can": true, "for": true,
+	"from": true, "had": true, "has": true, "have": true, "how": true,
+	"its": true, "not": true, "that": true, "the": true, "then": true,
+	"there": true, "this": true, "was": true, "were": true, "what": true,
+	"when": true, "which": true, "will": true, "with": true, "you": true,
+	"your": true,
+}
+
+// entryWords returns the counts of the interesting words in the given
+// content.
+func entryWords(content string) map[string]int {
+
+	text := strings.ToLower(htmlTag.ReplaceAllString(content, " "))
+
+	words := make(map[string]int)
+	for _, w := range wordRegexp.FindAllString(text, -1) {
+		if len(w) < 3 || stopWords[w] {
+			continue
+		}
+		words[w]++
+	}
+	return words
+}
+
+// relatedLimit is the most entries a term, or tag, may be found in for
+// it to relate them.
+//
+// Every pair of entries sharing a term, or tag, is compared, so without
+// a limit common terms would make finding related entries quadratic in
+// the number of entries.  Terms which are that common say little about
+// the entries they're found in anyway.
+const relatedLimit = 100
+
+// posting records the weight of a single term within one entry.
+type posting struct {
+	entry  int
+	weight float64
+}
+
+// RelatedEntries returns up to `count` related entries for every entry in
+// the site, keyed by the path of each entry, most related first.
+//
+// Entries are related if they share tags, or if their content is similar,
+// as measured by the cosine similarity of their TF-IDF vectors.  Both are
+// scored between zero and one, and added together.  Entries which are not
+// related at all are never returned, and ties are broken by date, then by
+// path, so the result is stable across rebuilds.
+//
+// Terms, and tags, which are shared by more than a hundred entries are
+// ignored, so that the time taken grows with the number of entries, not
+// with its square.
+func (e *Ephemeris) RelatedEntries(count int) map[string][]BlogEntry {
+
+	result := make(map[string][]BlogEntry)
+	if count <= 0 {
+		return result
+	}
+
+	entries := e.BlogEntries
+	n := len(entries)
+
+	//
+	// Count the words in each entry, and the number of entries
+	// containing each word.
+	//
+	words := make([]map[string]int, n)
+	df := make(map[string]int)
+	for i, entry := range entries {
+		words[i] = entryWords(entry.Title + " " + entry.Content)
+		for w := range words[i] {
+			df[w]++
+		}
+	}
+
+	//
+	// Build an index of the weight of each term, within each
+	// entry.  The vectors are normalised so that the dot-product
+	// of two entries is their cosine similarity.
+	//
+	// Terms found in only one entry can't relate two entries, and
+	// those found in more than half the entries, or more than
+	// relatedLimit entries, say little about any of them, so both
+	// are ignored.
+	//
+	index := make(map[string][]posting)
+	for i := range entries {
+
+		// The terms are always processed in the same order, so
+		// that the floating-point sums are the same upon each run.
+		var terms []string
+		for w := range words[i] {
+			if df[w] >= 2 && df[w]*2 <= n && df[w] <= relatedLimit {
+				terms = append(terms, w)
+			}
+		}
+		sort.Strings(terms)
+
+		weights := make([]float64, len(terms))
+		norm := 0.0
+		for t, w := range terms {
+			weights[t] = float64(words[i][w]) * math.Log(float64(n)/float64(df[w]))
+			norm += weights[t] * weights[t]
+		}
+		norm = math.Sqrt(norm)
+
+		for t, w := range terms {
+			index[w] = append(index[w], posting{entry: i, weight: weights[t] / norm})
+		}
+	}
+
+	var terms []string
+	for w := range index {
+		terms = append(terms, w)
+	}
+	sort.Strings(terms)
+
+	// scores[i][j] is the similarity of entry i to entry j.
+	scores := make([]map[int]float64, n)
+	for i := range scores {
+		scores[i] = make(map[int]float64)
+	}
+	for _, w := range terms {
+		postings := index[w]
+		for _, a := range postings {
+			for _, b := range postings {
+				if a.entry != b.entry {
+					scores[a.entry][b.entry] += a.weight * b.weight
+				}
+			}
+		}
+	}
+
+	//
+	// Add the overlap of the tags, as the size of the intersection
+	// divided by the size of the union.
+	//
+	tagged := make(map[string][]int)
+	for i, entry := range entries {
+		for _, t := range entry.Tags {
+			t = strings.ToLower(t)
+
+			// Ignore duplicate tags upon the same entry.
+			list := tagged[t]
+			if len(list) > 0 && list[len(list)-1] == i {
+				continue
+			}
+			tagged[t] = append(list, i)
+		}
+	}
+	shared := make([]map[int]int, n)
+	for i := range shared {
+		shared[i] = make(map[int]int)
+	}
+	for _, list := range tagged {
+		if len(list) > relatedLimit {
+			continue
+		}
+		for _, a := range list {
+			for _, b := range list {
+				if a != b {
+					shared[a][b]++
+				}
+			}
+		}
+	}
+	for i := range entries {
+		for j, common := range shared[i] {
+			union := len(entries[i].Tags) + len(entries[j].Tags) - common
+			scores[i][j] += float64(common) / float64(union)
+		}
+	}
+
+	//
+	// Now pick the best for each entry.
+	//
+	for i, entry := range entries {
+
+		var related []int
+		for j, score := range scores[i] {
+			if score > 0 {
+				related = append(related, j)
+			}
+		}
+
+		sort.Slice(related, func(x, y int) bool {
+			a, b := related[x], related[y]
+			if scores[i][a] != scores[i][b] {
+				return scores[i][a] > scores[i][b]
+			}
+			if !entries[a].Date.Equal(entries[b].Date) {
+				return entries[a].Date.After(entries[b].Date)
+			}
+			return entries[a].Path < entries[b].Path
+		})
+
+		if len(related) > count {
+			related = related[:count]
+		}
+
+		for _, j := range related {
+			result[entry.Path] = append(result[entry.Path], entries[j])
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,94 @@
+package ephemeris
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// titlesOf returns the titles of the given entries.
+func titlesOf(entries []BlogEntry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Title)
+	}
+	return out
+}
+
+// Test finding related entries.
+func TestRelatedEntries(t *testing.T) {
+
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(title string, days int, content string, tags ...string) BlogEntry {
+		return BlogEntry{Title: title, Path: title + ".txt", Date: date.AddDate(0, 0, days), Content: content, Tags: tags}
+	}
+
+	site := &Ephemeris{BlogEntries: []BlogEntry{
+		entry("golang", 1, "<p>Writing a compiler in golang, with a lexer and parser.</p>", "go", "compilers"),
+		entry("lexer", 2, "<p>The lexer of my compiler handles tokens.</p>", "compilers"),
+		entry("parser", 3, "<p>A parser consumes tokens from the lexer.</p>"),
+		entry("garden", 4, "<p>Planting potatoes in the garden.</p>", "garden"),
+		entry("potatoes", 5, "<p>Harvesting potatoes, from the garden.</p>", "garden"),
+		entry("holiday", 6, "<p>Nothing in common with anything here.</p>"),
+	}}
+
+	related := site.RelatedEntries(2)
+
+	expected := map[string][]string{
+		"golang.txt":   {"lexer", "parser"},
+		"lexer.txt":    {"golang", "parser"},
+		"garden.txt":   {"potatoes"},
+		"potatoes.txt": {"garden"},
+	}
+	for path, titles := range expected {
+		if got := related[path]; !reflect.DeepEqual(titlesOf(got), titles) {
+			t.Errorf("related entries of %s were %v not %v", path, titlesOf(got), titles)
+		}
+	}
+
+	// Unrelated entries have nothing.
+	if len(related["holiday.txt"]) != 0 {
+		t.Errorf("unexpected related entries %v", titlesOf(related["holiday.txt"]))
+	}
+
+	// The result is stable.
+	for i := 0; i < 10; i++ {
+		if again := site.RelatedEntries(2); !reflect.DeepEqual(again, related) {
+			t.Fatalf("related entries changed between runs")
+		}
+	}
+
+	// No count means nothing is returned.
+	if len(site.RelatedEntries(0)) != 0 {
+		t.Errorf("expected no related entries")
+	}
+}
+
+// Test that terms, and tags, shared by too many entries are ignored.
+func TestRelatedEntriesCommon(t *testing.T) {
+
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	site := &Ephemeris{}
+	for i := 0; i < relatedLimit*3; i++ {
+		content := fmt.Sprintf("<p>Another post about everything, number w%d.</p>", i)
+		if i < 2 {
+			content = "<p>Another post about everything, and zebras.</p>"
+		}
+		site.BlogEntries = append(site.BlogEntries, BlogEntry{
+			Title:   fmt.Sprintf("post %d", i),
+			Path:    fmt.Sprintf("%d.txt", i),
+			Date:    date.AddDate(0, 0, i),
+			Content: content,
+			Tags:    []string{"misc"},
+		})
+	}
+
+	related := site.RelatedEntries(5)
+
+	// Only the two entries sharing a rare term are related.
+	if len(related) != 2 || !reflect.DeepEqual(titlesOf(related["0.txt"]), []string{"post 1"}) {
+		t.Errorf("unexpected related entries %v", related)
+	}
+}
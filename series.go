@@ -0,0 +1,105 @@
+package ephemeris
+
+import (
+	"sort"
+	"strings"
+)
+
+// SeriesDirectory is the directory, relative to the output-path and the
+// URL-prefix, beneath which the series pages are written.
+const SeriesDirectory = "series"
+
+// Series holds the entries which are parts of a single series, as
+// identified by their "Series:" header.
+type Series struct {
+	// Name is the name of the series.
+	Name string
+
+	// Slug is the name of the series, as used in its link.
+	Slug string
+
+	// Link is the absolute link to the index of the series.
+	Link string
+
+	// Entries holds the parts of the series, in order.
+	Entries []BlogEntry
+}
+
+// seriesSlug returns the name of a series, as used in links.
+//
+// Names are compared case-insensitively, so "My Series" and "my series"
+// are the same series.
+func seriesSlug(name string) string {
+	return strings.ToLower(linkRegexp.ReplaceAllString(name, "_"))
+}
+
+// buildSeriesIndex groups the given entries into their series, keyed by
+// the slug of each series.
+//
+// Entries with a "Part:" header come first, in the order of their parts,
+// followed by those without, in the order they were published.
+func buildSeriesIndex(entries []BlogEntry, prefix string) map[string]*Series {
+
+	index := make(map[string]*Series)
+
+	for _, entry := range entries {
+		if entry.Series == "" {
+			continue
+		}
+
+		slug := seriesSlug(entry.Series)
+		s, ok := index[slug]
+		if !ok {
+			s = &Series{Slug: slug, Link: prefix + SeriesDirectory + "/" + slug + "/"}
+			index[slug] = s
+		}
+		s.Entries = append(s.Entries, entry)
+	}
+
+	for _, s := range index {
+		sort.SliceStable(s.Entries, func(i, j int) bool {
+			a, b := s.Entries[i], s.Entries[j]
+
+			// Numbered parts come first.
+			if (a.Part == 0) != (b.Part == 0) {
+				return a.Part != 0
+			}
+			if a.Part != b.Part {
+				return a.Part < b.Part
+			}
+			if !a.Date.Equal(b.Date) {
+				return a.Date.Before(b.Date)
+			}
+			return a.Path < b.Path
+		})
+
+		// The name is taken from the first part, so that it is
+		// stable.
+		s.Name = s.Entries[0].Series
+	}
+
+	return index
+}
+
+// AllSeries returns every series within the site, sorted by name.
+func (e *Ephemeris) AllSeries() []*Series {
+
+	var all []*Series
+	for _, s := range e.SeriesIndex {
+		all = append(all, s)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Slug < all[j].Slug
+	})
+	return all
+}
+
+// SeriesOf returns the series the given entry is part of, or nil if it
+// isn't part of any series.
+func (e *Ephemeris) SeriesOf(entry BlogEntry) *Series {
+	if entry.Series == "" {
+		return nil
+	}
+	return e.SeriesIndex[seriesSlug(entry.Series)]
+}
@@ -0,0 +1,50 @@
+package ephemeris
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test grouping entries into series.
+func TestSeriesIndex(t *testing.T) {
+
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(title string, days int, series string, part int) BlogEntry {
+		return BlogEntry{Title: title, Path: title + ".txt", Date: date.AddDate(0, 0, days), Series: series, Part: part}
+	}
+
+	site := &Ephemeris{Prefix: "https://example.com/"}
+	site.BlogEntries = []BlogEntry{
+		entry("two", 1, "Writing a Compiler", 2),
+		entry("one", 2, "writing a compiler", 1),
+		entry("epilogue", 3, "Writing a Compiler", 0),
+		entry("aside", 0, "Writing a Compiler", 0),
+		entry("plain", 4, "", 0),
+		entry("spring", 5, "Gardening", 0),
+	}
+	site.SeriesIndex = buildSeriesIndex(site.BlogEntries, site.Prefix)
+
+	all := site.AllSeries()
+	if len(all) != 2 || all[0].Slug != "gardening" || all[1].Slug != "writing_a_compiler" {
+		t.Fatalf("unexpected series %v", all)
+	}
+
+	s := all[1]
+	if s.Name != "writing a compiler" || s.Link != "https://example.com/series/writing_a_compiler/" {
+		t.Errorf("unexpected series %s %s", s.Name, s.Link)
+	}
+
+	// Numbered parts first, then the rest by date.
+	expected := []string{"one", "two", "aside", "epilogue"}
+	if got := titlesOf(s.Entries); !reflect.DeepEqual(got, expected) {
+		t.Errorf("series was ordered %v not %v", got, expected)
+	}
+
+	if site.SeriesOf(site.BlogEntries[0]) != s {
+		t.Errorf("failed to find the series of an entry")
+	}
+	if site.SeriesOf(site.BlogEntries[4]) != nil {
+		t.Errorf("found a series for an entry without one")
+	}
+}
@@ -8,9 +8,11 @@
 package ephemeris
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 )
@@ -32,13 +34,93 @@ type Ephemeris struct {
 
 	// Prefix is the absolute URL prefix for the blog
 	Prefix string
+
+	// SeriesIndex holds the series of entries within the site, keyed
+	// by the slug of each series.
+	SeriesIndex map[string]*Series
+
+	// Options holds the optional settings used to load the site.
+	Options Options
+
+	// cache holds the parsed posts, if a CachePath is set.
+	cache *postCache
+}
+
+// Options holds optional settings which affect how a site is loaded.
+//
+// The zero-value is valid, and results in the default behaviour.
+type Options struct {
+	// Avatars controls the icons shown for comment-submitters, and
+	// must be one of AvatarNone, AvatarGravatar, or AvatarIdenticon.
+	//
+	// If this is empty then gravatar is used.
+	Avatars string
+
+	// AvatarSalt is mixed into the hash of each submitter's email
+	// address, when identicons are used, and is required by them.
+	AvatarSalt string
+
+	// AnonymizeIP controls how the IP addresses of comment-submitters
+	// are anonymised, and must be one of AnonymizeNone,
+	// AnonymizeTruncate, or AnonymizeHash.
+	//
+	// If this is empty addresses are unchanged.
+	AnonymizeIP string
+
+	// AnonymizeSecret is the secret key of the hash of each address,
+	// which is required when addresses are hashed.
+	AnonymizeSecret string
+
+	// CommentDays is the number of days after publication for which
+	// entries accept comments, unless they have a "Comments:" header.
+	CommentDays int
+
+	// Jobs is the number of posts which are parsed concurrently,
+	// defaulting to the number of CPUs.
+	Jobs int
+
+	// CachePath is the directory in which parsed posts are cached,
+	// keyed by a hash of their contents, so that unchanged posts
+	// needn't be rendered again.  They're written to a "posts"
+	// subdirectory, so the directory may be shared with other caches.
+	//
+	// If this is empty posts aren't cached.
+	CachePath string
 }
 
-// New creates a new site object.
+// New creates a new site object, using the default options.
 func New(directory string, commentPath string, prefix string) (*Ephemeris, error) {
+	return NewWithOptions(directory, commentPath, prefix, Options{})
+}
+
+// NewWithOptions creates a new site object, using the specified options.
+func NewWithOptions(directory string, commentPath string, prefix string, options Options) (*Ephemeris, error) {
 
 	// Create object
-	x := &Ephemeris{Root: directory, Prefix: prefix}
+	x := &Ephemeris{Root: directory, Prefix: prefix, Options: options}
+	if options.CachePath != "" {
+		x.cache = &postCache{dir: filepath.Join(options.CachePath, postCacheDir)}
+	}
+
+	// Ensure the avatar-provider is one we know about.
+	switch options.Avatars {
+	case "", AvatarNone, AvatarGravatar, AvatarIdenticon:
+	default:
+		return x, fmt.Errorf("unknown avatar-provider %s", options.Avatars)
+	}
+	if options.Avatars == AvatarIdenticon && options.AvatarSalt == "" {
+		return x, fmt.Errorf("identicons require a salt")
+	}
+
+	// Ensure the anonymisation is one we know about.
+	switch options.AnonymizeIP {
+	case "", AnonymizeNone, AnonymizeTruncate, AnonymizeHash:
+	default:
+		return x, fmt.Errorf("unknown IP anonymisation %s", options.AnonymizeIP)
+	}
+	if options.AnonymizeIP == AnonymizeHash && options.AnonymizeSecret == "" {
+		return x, fmt.Errorf("hashing IP addresses requires a secret")
+	}
 
 	// If the comment-path is set we'll load comments
 	if commentPath != "" {
@@ -72,6 +154,7 @@ func New(directory string, commentPath string, prefix string) (*Ephemeris, error
 	//
 	// Find the blog-posts, recursively.
 	//
+	var paths []string
 	if directory != "" {
 		err = filepath.Walk(directory,
 			func(path string, info os.FileInfo, err error) error {
@@ -86,22 +169,55 @@ func New(directory string, commentPath string, prefix string) (*Ephemeris, error
 					return nil
 				}
 
-				// Parse the blog-post from the file.
-				out, err := NewBlogEntry(path, x)
-				if err != nil {
-					return fmt.Errorf("failed to parse %s - %s", path, err.Error())
-				}
-
-				// Store the result.
-				x.BlogEntries = append(x.BlogEntries, out)
+				// Store the path.
+				paths = append(paths, path)
 
 				// Continue walking.
 				return nil
 			})
 	}
+	if err != nil {
+		return x, err
+	}
+
+	//
+	// Parse the blog-posts, several at once.  They're stored in the
+	// order they were found, regardless of when they were parsed.
+	//
+	jobs := options.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	entries := make([]BlogEntry, len(paths))
+	err = forEach(context.Background(), jobs, len(paths), func(i int) error {
+
+		// Parse the blog-post from the file.
+		out, err := NewBlogEntry(paths[i], x)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s - %s", paths[i], err.Error())
+		}
+
+		// Store the result.
+		entries[i] = out
+		return nil
+	})
+	if err != nil {
+		return x, err
+	}
+	x.BlogEntries = append(x.BlogEntries, entries...)
+
+	// Remove the cached posts we didn't use.
+	err = x.cache.prune()
+	if err != nil {
+		return x, err
+	}
+
+	// Group the entries into their series.
+	x.SeriesIndex = buildSeriesIndex(x.BlogEntries, x.Prefix)
 
 	// Return the entries we found.
-	return x, err
+	return x, nil
 }
 
 // Entries returns the blog-entries contained within a site.  Note that
@@ -125,9 +241,7 @@ func (e *Ephemeris) Recent(count int) []BlogEntry {
 
 	// Sort the list of posts by date.
 	sort.Slice(e.BlogEntries, func(i, j int) bool {
-		a := e.BlogEntries[i].Date
-		b := e.BlogEntries[j].Date
-		return a.Before(b)
+		return entryBefore(e.BlogEntries[i], e.BlogEntries[j])
 	})
 
 	// We want to include at-max `count` posts.
@@ -145,3 +259,76 @@ func (e *Ephemeris) Recent(count int) []BlogEntry {
 	// All done
 	return recent
 }
+
+// Newest returns the most recent entry of the site, or an empty entry if
+// there are none.
+func (e *Ephemeris) Newest() BlogEntry {
+
+	var newest BlogEntry
+	for i, entry := range e.BlogEntries {
+		if i == 0 || entryBefore(newest, entry) {
+			newest = entry
+		}
+	}
+	return newest
+}
+
+// entryBefore returns true if entry a was published before entry b.
+//
+// Entries published at the same time are ordered by their paths, so that
+// Recent and Newest agree upon which is the most recent.
+func entryBefore(a BlogEntry, b BlogEntry) bool {
+	if a.Date.Equal(b.Date) {
+		return a.Path < b.Path
+	}
+	return a.Date.Before(b.Date)
+}
+
+// EntryComment holds a comment, along with the details of the entry
+// upon which it was left.
+type EntryComment struct {
+	BlogComment
+
+	// EntryTitle holds the title of the entry.
+	EntryTitle string
+
+	// EntryLink holds the link to the entry.
+	EntryLink string
+}
+
+// RecentComments returns the most recent N comments from across the
+// whole site, newest first.
+//
+// If the count is zero, or negative, no comments are returned.
+func (e *Ephemeris) RecentComments(count int) []EntryComment {
+
+	var all []EntryComment
+	if count <= 0 {
+		return all
+	}
+
+	for _, entry := range e.BlogEntries {
+		for _, comment := range entry.CommentData {
+			all = append(all, EntryComment{
+				BlogComment: comment,
+				EntryTitle:  entry.Title,
+				EntryLink:   entry.Link,
+			})
+		}
+	}
+
+	// Sort the comments by date, newest first.  Comments made at
+	// the same time are ordered by their entry, so the output is
+	// stable.
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Date.Equal(all[j].Date) {
+			return all[i].EntryLink < all[j].EntryLink
+		}
+		return all[i].Date.After(all[j].Date)
+	})
+
+	if len(all) > count {
+		all = all[:count]
+	}
+	return all
+}
@@ -1,6 +1,10 @@
 package ephemeris
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -89,3 +93,145 @@ func TestMissingDirectories(t *testing.T) {
 	}
 
 }
+
+// TestRecentComments - Ensure we find the most recent comments first
+func TestRecentComments(t *testing.T) {
+
+	x, err := New("_demo/data", "_demo/comments", "https://steve.kemp.example.com/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	comments := x.RecentComments(10)
+	if len(comments) != 2 {
+		t.Fatalf("Expected two comments, found %d", len(comments))
+	}
+
+	if !comments[0].Date.After(comments[1].Date) {
+		t.Errorf("Comments were not sorted newest-first")
+	}
+	if comments[0].EntryTitle != "This post has some comments" {
+		t.Errorf("Comment has the wrong entry-title: %s", comments[0].EntryTitle)
+	}
+	if comments[0].EntryLink != "https://steve.kemp.example.com/This_post_has_some_comments.html" {
+		t.Errorf("Comment has the wrong entry-link: %s", comments[0].EntryLink)
+	}
+
+	// Limits are respected
+	comments = x.RecentComments(1)
+	if len(comments) != 1 {
+		t.Fatalf("Expected one comment, found %d", len(comments))
+	}
+
+	// Bogus limits return nothing, rather than panicking
+	for _, count := range []int{0, -1} {
+		if comments = x.RecentComments(count); len(comments) != 0 {
+			t.Errorf("Expected no comments for %d, found %d", count, len(comments))
+		}
+	}
+
+	// And the comment-count is available upon each entry
+	for _, ent := range x.Entries() {
+		if ent.CommentCount() != len(ent.CommentData) {
+			t.Errorf("Wrong comment-count for %s", ent.Title)
+		}
+	}
+}
+
+// TestParallelParsing - Ensure the entries don't depend upon the number
+// of posts parsed at once.
+func TestParallelParsing(t *testing.T) {
+
+	serial, err := NewWithOptions("_demo/data", "_demo/comments", "https://example.com/", Options{Jobs: 1})
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+	parallel, err := NewWithOptions("_demo/data", "_demo/comments", "https://example.com/", Options{Jobs: 8})
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+
+	if len(serial.Entries()) == 0 || !reflect.DeepEqual(serial.Entries(), parallel.Entries()) {
+		t.Errorf("Entries differed when parsed in parallel")
+	}
+}
+
+// benchmarkPosts is the number of posts in the corpus used by the
+// benchmarks.
+const benchmarkPosts = 10000
+
+// writeCorpus writes a synthetic corpus of Markdown posts, returning
+// the directory which holds them.
+func writeCorpus(b *testing.B) string {
+
+	b.Helper()
+
+	dir := b.TempDir()
+	for i := 0; i < benchmarkPosts; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("%d", 2000+i%20))
+		os.MkdirAll(sub, 0755)
+
+		post := fmt.Sprintf(`Title: Post number %d
+Date: %02d/%02d/%d 12:00
+Tags: tag%d, tag%d, benchmark
+Format: markdown
+
+# Post %d
+
+Some *emphasised* text, some **strong** text, and [a link](https://example.com/%d).
+
+* One
+* Two
+* Three
+
+`+"```go\nfmt.Println(%d)\n```"+`
+`, i, 1+i%28, 1+i%12, 2000+i%20, i%50, i%7, i, i, i)
+
+		err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("post-%d.txt", i)), []byte(post), 0644)
+		if err != nil {
+			b.Fatalf("failed to write post: %s", err.Error())
+		}
+	}
+	return dir
+}
+
+// benchmarkNew loads the corpus with the given options.
+func benchmarkNew(b *testing.B, dir string, options Options) {
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		site, err := NewWithOptions(dir, "", "https://example.com/", options)
+		if err != nil {
+			b.Fatalf("error creating site: %s", err.Error())
+		}
+		if len(site.Entries()) != benchmarkPosts {
+			b.Fatalf("found %d posts", len(site.Entries()))
+		}
+	}
+}
+
+// BenchmarkNewSerial - Parse the corpus one post at a time.
+func BenchmarkNewSerial(b *testing.B) {
+	benchmarkNew(b, writeCorpus(b), Options{Jobs: 1})
+}
+
+// BenchmarkNewParallel - Parse the corpus with a job per CPU.
+func BenchmarkNewParallel(b *testing.B) {
+	benchmarkNew(b, writeCorpus(b), Options{})
+}
+
+// BenchmarkNewCached - Parse the corpus with a job per CPU, and a cache
+// which already holds every post.
+func BenchmarkNewCached(b *testing.B) {
+
+	dir := writeCorpus(b)
+	options := Options{CachePath: b.TempDir()}
+
+	// Fill the cache.
+	_, err := NewWithOptions(dir, "", "https://example.com/", options)
+	if err != nil {
+		b.Fatalf("error creating site: %s", err.Error())
+	}
+
+	benchmarkNew(b, dir, options)
+}
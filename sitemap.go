@@ -0,0 +1,143 @@
+package ephemeris
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SitemapURL holds a single location within a sitemap.
+type SitemapURL struct {
+	// Loc is the absolute URL of the page.
+	Loc string
+
+	// LastMod is when the page last changed, if known.
+	LastMod time.Time
+}
+
+// Sitemap returns the locations of every page generated for the site,
+// along with the time each was last modified, sorted by location.
+//
+// The sitemap includes the index, each entry, each tag page, each
+// archive page, each series, and the given standalone pages.  An entry
+// is modified when it is published, or when a comment is left upon it,
+// and each listing is modified when the newest entry within it is.
+func (e *Ephemeris) Sitemap(pages []Page) []SitemapURL {
+
+	latest := make(map[string]time.Time)
+
+	// record notes that the given location was modified at the given
+	// time, keeping the most recent time seen.
+	record := func(loc string, t time.Time) {
+		if existing, ok := latest[loc]; !ok || t.After(existing) {
+			latest[loc] = t
+		}
+	}
+
+	record(e.Prefix, time.Time{})
+
+	for _, entry := range e.BlogEntries {
+		modified := entryModified(entry)
+
+		// Entries are written to the lower-cased version of
+		// their link.
+		record(strings.ToLower(entry.Link), modified)
+		record(e.Prefix, modified)
+
+		record(e.Prefix+"tags/", modified)
+		for _, tag := range entry.Tags {
+			record(e.Prefix+"tags/"+url.PathEscape(tag)+"/", modified)
+		}
+
+		record(e.Prefix+"archive/", modified)
+		record(e.Prefix+"archive/"+entry.Year()+"/"+entry.MonthNumber()+"/", modified)
+	}
+
+	for _, series := range e.SeriesIndex {
+		for _, entry := range series.Entries {
+			record(series.Link, entryModified(entry))
+		}
+	}
+
+	for _, page := range pages {
+		record(page.Link, page.Date)
+	}
+
+	var result []SitemapURL
+	for loc, t := range latest {
+		result = append(result, SitemapURL{Loc: loc, LastMod: t})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Loc < result[j].Loc
+	})
+	return result
+}
+
+// entryModified returns the time an entry was last modified, which is
+// the time of the most recent comment upon it, if any.
+func entryModified(entry BlogEntry) time.Time {
+	modified := entry.Date
+	for _, comment := range entry.CommentData {
+		if comment.Date.After(modified) {
+			modified = comment.Date
+		}
+	}
+	return modified
+}
+
+// WriteSitemap writes the given locations to the writer, in the format
+// described at https://www.sitemaps.org/protocol.html
+func WriteSitemap(w io.Writer, urls []SitemapURL) error {
+
+	type sitemapEntry struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod,omitempty"`
+	}
+
+	type urlset struct {
+		XMLName xml.Name       `xml:"urlset"`
+		XMLNS   string         `xml:"xmlns,attr"`
+		URLs    []sitemapEntry `xml:"url"`
+	}
+
+	set := urlset{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, u := range urls {
+		entry := sitemapEntry{Loc: u.Loc}
+		if !u.LastMod.IsZero() {
+			entry.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, entry)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(set)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// Robots returns the contents of a robots.txt file, containing the given
+// rules followed by a reference to the sitemap beneath the prefix.
+//
+// If there are no rules then all robots are allowed to index everything.
+func Robots(prefix string, rules string) string {
+
+	rules = strings.TrimSpace(rules)
+	if rules == "" {
+		rules = "User-agent: *\nDisallow:"
+	}
+
+	return rules + "\n\nSitemap: " + prefix + "sitemap.xml\n"
+}
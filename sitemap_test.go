@@ -0,0 +1,105 @@
+package ephemeris
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test building the sitemap of a site.
+func TestSitemap(t *testing.T) {
+
+	date := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	site := &Ephemeris{Prefix: "https://example.com/"}
+	site.BlogEntries = []BlogEntry{
+		{Title: "First", Path: "first.txt", Link: "https://example.com/First.html", Date: date, Tags: []string{"go", "c++"}},
+		{Title: "Second", Path: "second.txt", Link: "https://example.com/Second.html", Date: date.AddDate(0, 1, 0), Tags: []string{"go"}, Series: "Compilers"},
+	}
+
+	// A comment upon the first entry, after the second was published.
+	commented := date.AddDate(0, 2, 0)
+	site.BlogEntries[0].CommentData = []BlogComment{{Date: commented}}
+	site.SeriesIndex = buildSeriesIndex(site.BlogEntries, site.Prefix)
+
+	pages := []Page{
+		{Link: "https://example.com/about/"},
+	}
+
+	expected := map[string]time.Time{
+		"https://example.com/":                  commented,
+		"https://example.com/about/":            {},
+		"https://example.com/archive/":          commented,
+		"https://example.com/archive/2020/03/":  commented,
+		"https://example.com/archive/2020/04/":  date.AddDate(0, 1, 0),
+		"https://example.com/first.html":        commented,
+		"https://example.com/second.html":       date.AddDate(0, 1, 0),
+		"https://example.com/series/compilers/": date.AddDate(0, 1, 0),
+		"https://example.com/tags/":             commented,
+		"https://example.com/tags/c++/":         commented,
+		"https://example.com/tags/go/":          commented,
+	}
+
+	urls := site.Sitemap(pages)
+	if len(urls) != len(expected) {
+		t.Fatalf("expected %d locations, got %d: %v", len(expected), len(urls), urls)
+	}
+
+	for i, u := range urls {
+		if i > 0 && urls[i-1].Loc >= u.Loc {
+			t.Errorf("locations are not sorted: %s then %s", urls[i-1].Loc, u.Loc)
+		}
+		when, ok := expected[u.Loc]
+		if !ok {
+			t.Errorf("unexpected location %s", u.Loc)
+			continue
+		}
+		if !when.Equal(u.LastMod) {
+			t.Errorf("%s was modified %s not %s", u.Loc, u.LastMod, when)
+		}
+	}
+}
+
+// Test writing a sitemap.
+func TestWriteSitemap(t *testing.T) {
+
+	var out bytes.Buffer
+
+	err := WriteSitemap(&out, []SitemapURL{
+		{Loc: "https://example.com/", LastMod: time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{Loc: "https://example.com/about/?a=b&c=d"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://example.com/</loc>
+    <lastmod>2020-03-01T12:00:00Z</lastmod>
+  </url>
+  <url>
+    <loc>https://example.com/about/?a=b&amp;c=d</loc>
+  </url>
+</urlset>
+`
+	if out.String() != expected {
+		t.Errorf("unexpected sitemap:\n%s", out.String())
+	}
+}
+
+// Test generating robots.txt.
+func TestRobots(t *testing.T) {
+
+	out := Robots("https://example.com/", "")
+	if out != "User-agent: *\nDisallow:\n\nSitemap: https://example.com/sitemap.xml\n" {
+		t.Errorf("unexpected default robots.txt:\n%s", out)
+	}
+
+	out = Robots("https://example.com/", "User-agent: *\nDisallow: /cgi-bin/\n")
+	if !strings.HasPrefix(out, "User-agent: *\nDisallow: /cgi-bin/\n\nSitemap: ") {
+		t.Errorf("unexpected robots.txt:\n%s", out)
+	}
+}
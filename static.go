@@ -0,0 +1,313 @@
+package ephemeris
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// StaticDirectory is the directory, within a theme, which holds static
+// assets to be copied into the output.
+const StaticDirectory = "static"
+
+// staticFile records where a single static file is copied from.
+type staticFile struct {
+	fsys fs.FS
+	name string
+}
+
+// StaticFiles is a collection of static files, such as images, CSS, and
+// downloads, which are mirrored into the output-directory.
+//
+// Files are added from one or more sources, and if the same file is
+// present in several sources then the one added last is used.
+type StaticFiles struct {
+	files map[string]staticFile
+}
+
+// StaticResult reports what happened when static files were copied.
+type StaticResult struct {
+	// Copied is the number of files which were copied.
+	Copied int
+
+	// Unchanged is the number of files which were already present,
+	// and so were not copied again.
+	Unchanged int
+}
+
+// Add adds every file beneath the given filesystem, except for those
+// which the given function returns true for.
+//
+// Hidden files and directories, whose names begin with ".", are always
+// skipped.  The skip-function may be nil.
+func (s *StaticFiles) Add(fsys fs.FS, skip func(name string) bool) error {
+
+	if s.files == nil {
+		s.files = make(map[string]staticFile)
+	}
+
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() || (skip != nil && skip(name)) {
+			return nil
+		}
+
+		s.files[name] = staticFile{fsys: fsys, name: name}
+		return nil
+	})
+}
+
+// Names returns the names of the files, relative to the output-directory,
+// in sorted order.
+func (s *StaticFiles) Names() []string {
+
+	var names []string
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the named file, from the source it was added from, so that
+// the files may be read as though they were a single filesystem.
+//
+// Only files may be opened, not directories.
+func (s *StaticFiles) Open(name string) (fs.File, error) {
+
+	f, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.fsys.Open(f.name)
+}
+
+// Copy copies the files into the given output.
+//
+// If the output is an IncrementalFS then files which are already present,
+// with the same size and modification time, are skipped.  Copied files
+// are given the modification time of their source, so that they're
+// skipped upon the next run.  Sources which have no modification time,
+// such as embedded files, are compared by their contents instead.
+func (s *StaticFiles) Copy(output OutputFS) (StaticResult, error) {
+
+	var result StaticResult
+
+	existing, incremental := output.(IncrementalFS)
+
+	for _, name := range s.Names() {
+		f := s.files[name]
+
+		info, err := fs.Stat(f.fsys, f.name)
+		if err != nil {
+			return result, err
+		}
+
+		if incremental {
+			same, err := unchanged(f, info, existing, name)
+			if err != nil {
+				return result, err
+			}
+			if same {
+				result.Unchanged++
+				continue
+			}
+		}
+
+		err = copyStaticFile(f, info, output, name)
+		if err != nil {
+			return result, err
+		}
+		result.Copied++
+	}
+
+	return result, nil
+}
+
+// unchanged returns true if the named file within the output is already
+// a copy of the given source file.
+func unchanged(f staticFile, info fs.FileInfo, output IncrementalFS, name string) (bool, error) {
+
+	existing, err := output.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if existing.Size() != info.Size() {
+		return false, nil
+	}
+
+	if !info.ModTime().IsZero() {
+		return existing.ModTime().Equal(info.ModTime()), nil
+	}
+
+	src, err := fs.ReadFile(f.fsys, f.name)
+	if err != nil {
+		return false, err
+	}
+	dst, err := fs.ReadFile(output, name)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(src, dst), nil
+}
+
+// copyStaticFile copies a single file to the named file within the
+// output.
+func copyStaticFile(f staticFile, info fs.FileInfo, output OutputFS, name string) error {
+
+	in, err := f.fsys.Open(f.name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := output.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	if times, ok := output.(IncrementalFS); ok && !info.ModTime().IsZero() {
+		return times.Chtimes(name, info.ModTime())
+	}
+	return nil
+}
+
+// IsPostFile returns true if the named file is a blog-post, or page,
+// rather than a static file which is co-located with them.
+func IsPostFile(name string) bool {
+	return path.Ext(name) == ".txt"
+}
+
+// assetExtensions holds the extensions of the files, co-located with
+// posts and pages, which are copied into the output.
+var assetExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".svg": true,
+	".mp3": true, ".ogg": true, ".mp4": true, ".webm": true,
+	".pdf": true, ".zip": true, ".gz": true,
+}
+
+// IsAsset returns true if the named file, which is co-located with posts,
+// or pages, is an asset such as an image, which is copied into the
+// output, rather than a post, or some other file.
+func IsAsset(name string) bool {
+	return assetExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// Rewrite rewrites the relative links, and images, within the given HTML
+// which refer to static files, so that they still work once the HTML is
+// written to a different directory than it was read from.
+//
+// The source is the directory of the post, or page, relative to the
+// static files, and output is the directory it is written to, relative
+// to the output.  Each link which needs rewriting becomes an absolute
+// link beneath the given prefix.
+func (s *StaticFiles) Rewrite(content string, source string, output string, prefix string) string {
+
+	var out strings.Builder
+
+	tokens := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := tokens.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+
+		raw := string(tokens.Raw())
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			out.WriteString(raw)
+			continue
+		}
+
+		tok := tokens.Token()
+		changed := false
+		for i, attr := range tok.Attr {
+			if attr.Key != "href" && attr.Key != "src" {
+				continue
+			}
+			if link, ok := s.rewriteLink(attr.Val, source, output, prefix); ok {
+				tok.Attr[i].Val = link
+				changed = true
+			}
+		}
+
+		if changed {
+			out.WriteString(tok.String())
+		} else {
+			out.WriteString(raw)
+		}
+	}
+
+	return out.String()
+}
+
+// rewriteLink returns the absolute version of the given relative link,
+// if it refers to a static file, and would otherwise break.
+func (s *StaticFiles) rewriteLink(link string, source string, output string, prefix string) (string, bool) {
+
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+
+	name := path.Join(source, u.Path)
+	if name == path.Join(output, u.Path) || !fs.ValidPath(name) {
+		return "", false
+	}
+	if _, ok := s.files[name]; !ok {
+		return "", false
+	}
+
+	u.Path = name
+	return prefix + strings.TrimPrefix(u.String(), "/"), true
+}
+
+// RewriteStatic rewrites the links within every entry of the site which
+// refer to the static files co-located with it.
+//
+// Entries are written to the top of the output, so the links within
+// entries beneath sub-directories of the posts would otherwise break.
+func (e *Ephemeris) RewriteStatic(s *StaticFiles) {
+
+	for i := range e.BlogEntries {
+		dir := "."
+		if rel, err := filepath.Rel(e.Root, filepath.Dir(e.BlogEntries[i].Path)); err == nil {
+			dir = filepath.ToSlash(rel)
+		}
+		e.BlogEntries[i].Content = s.Rewrite(e.BlogEntries[i].Content, dir, ".", e.Prefix)
+	}
+
+	// The series hold copies of the entries.
+	e.SeriesIndex = buildSeriesIndex(e.BlogEntries, e.Prefix)
+}
@@ -0,0 +1,165 @@
+package ephemeris
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Test copying static files into the output.
+func TestStaticFiles(t *testing.T) {
+
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	theme := fstest.MapFS{
+		"style.css":    {Data: []byte("body {}")},
+		"logo.png":     {Data: []byte("theme-logo")},
+		".hidden":      {Data: []byte("secret")},
+		".git/config":  {Data: []byte("secret")},
+		"fonts/a.woff": {Data: []byte("font")},
+	}
+	static := fstest.MapFS{
+		"logo.png": {Data: []byte("site-logo"), ModTime: when},
+	}
+	posts := fstest.MapFS{
+		"2020/entry.txt": {Data: []byte("Title: Entry\n\nHello\n")},
+		"2020/cat.jpg":   {Data: []byte("meow"), ModTime: when},
+	}
+
+	var s StaticFiles
+	for _, fsys := range []fstest.MapFS{theme, static, posts} {
+		err := s.Add(fsys, IsPostFile)
+		if err != nil {
+			t.Fatalf("unexpected error adding files %s", err.Error())
+		}
+	}
+
+	expected := []string{"2020/cat.jpg", "fonts/a.woff", "logo.png", "style.css"}
+	if !reflect.DeepEqual(s.Names(), expected) {
+		t.Fatalf("unexpected files %v", s.Names())
+	}
+
+	dir := t.TempDir()
+	result, err := s.Copy(DirOutput(dir))
+	if err != nil {
+		t.Fatalf("unexpected error copying files %s", err.Error())
+	}
+	if result.Copied != 4 || result.Unchanged != 0 {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	// The last source wins.
+	data, _ := os.ReadFile(filepath.Join(dir, "logo.png"))
+	if string(data) != "site-logo" {
+		t.Errorf("unexpected logo %s", data)
+	}
+
+	// Copying again does nothing.
+	result, err = s.Copy(DirOutput(dir))
+	if err != nil || result.Copied != 0 || result.Unchanged != 4 {
+		t.Errorf("unexpected result copying again %v %v", result, err)
+	}
+
+	// Changing the sources copies them again.
+	theme["style.css"].Data = []byte("body { color: red; }")
+	posts["2020/cat.jpg"].ModTime = when.Add(time.Hour)
+	result, err = s.Copy(DirOutput(dir))
+	if err != nil || result.Copied != 2 || result.Unchanged != 2 {
+		t.Errorf("unexpected result after changes %v %v", result, err)
+	}
+}
+
+// Test reading, and copying, static files to an output which is not a
+// directory.
+func TestStaticFilesMemory(t *testing.T) {
+
+	var s StaticFiles
+	s.Add(fstest.MapFS{"style.css": {Data: []byte("body {}")}}, nil)
+	s.Add(fstest.MapFS{"img/cat.jpg": {Data: []byte("meow")}}, nil)
+
+	// The files may be read, from their sources.
+	data, err := fs.ReadFile(&s, "img/cat.jpg")
+	if err != nil || string(data) != "meow" {
+		t.Errorf("unexpected contents %s %v", data, err)
+	}
+	if _, err := s.Open("missing.jpg"); err == nil {
+		t.Errorf("expected an error opening a missing file")
+	}
+
+	// Copying to an archive always copies.
+	var buf bytes.Buffer
+	archive, _ := NewArchive(&buf, "site.zip")
+	result, err := s.Copy(archive)
+	if err != nil || result.Copied != 2 {
+		t.Errorf("unexpected result %v %v", result, err)
+	}
+
+	// Copying to memory skips unchanged files.
+	output := &MemoryOutput{}
+	s.Copy(output)
+	result, err = s.Copy(output)
+	if err != nil || result.Unchanged != 2 {
+		t.Errorf("unexpected result copying again %v %v", result, err)
+	}
+}
+
+// Test that only assets are copied from alongside posts.
+func TestIsAsset(t *testing.T) {
+
+	for name, expected := range map[string]bool{
+		"2020/cat.jpg":          true,
+		"2020/Cat.PNG":          true,
+		"talk.pdf":              true,
+		"2020/entry.txt":        false,
+		"notes.html.1577836800": false,
+		"drafts/secret.md":      false,
+	} {
+		if IsAsset(name) != expected {
+			t.Errorf("IsAsset(%s) != %t", name, expected)
+		}
+	}
+}
+
+// Test rewriting the links to co-located files.
+func TestStaticRewrite(t *testing.T) {
+
+	var s StaticFiles
+	s.Add(fstest.MapFS{
+		"2020/cat.jpg": {Data: []byte("meow")},
+		"dog.jpg":      {Data: []byte("woof")},
+	}, nil)
+
+	tests := []struct {
+		content string
+		source  string
+		output  string
+		result  string
+	}{
+		// Entries in sub-directories are written to the top.
+		{`<img src="cat.jpg">`, "2020", ".", `<img src="https://example.com/2020/cat.jpg">`},
+		{`<a href="cat.jpg#top">x</a>`, "2020", ".", `<a href="https://example.com/2020/cat.jpg#top">x</a>`},
+		{`<img src="../dog.jpg">`, "2020", ".", `<img src="https://example.com/dog.jpg">`},
+
+		// Pages are written to sub-directories.
+		{`<img src="dog.jpg">`, ".", "about", `<img src="https://example.com/dog.jpg">`},
+
+		// Links which work already, or aren't to static files,
+		// are unchanged.
+		{`<img src="dog.jpg">`, ".", ".", `<img src="dog.jpg">`},
+		{`<img src="missing.jpg">`, "2020", ".", `<img src="missing.jpg">`},
+		{`<img src="/2020/cat.jpg">`, "2020", ".", `<img src="/2020/cat.jpg">`},
+		{`<a href="https://example.org/cat.jpg">x</a>`, "2020", ".", `<a href="https://example.org/cat.jpg">x</a>`},
+	}
+
+	for _, test := range tests {
+		out := s.Rewrite(test.content, test.source, test.output, "https://example.com/")
+		if out != test.result {
+			t.Errorf("%s became %s, not %s", test.content, out, test.result)
+		}
+	}
+}
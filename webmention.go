@@ -0,0 +1,658 @@
+package ephemeris
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/skx/headerfile"
+	xhtml "golang.org/x/net/html"
+)
+
+// WebmentionType is the value of the "Type:" header in the comment-files
+// we write for received webmentions.
+const WebmentionType = "webmention"
+
+// maxWebmentionBody is the most we'll read from a remote page, when
+// discovering endpoints or verifying received mentions.
+const maxWebmentionBody = 1024 * 1024
+
+// OutboundLinks returns the absolute links contained within the given
+// HTML content, which is typically the body of a blog-entry.
+//
+// Links which begin with the given prefix are internal to the blog,
+// and are ignored.  The result is sorted, and contains no duplicates.
+func OutboundLinks(content string, prefix string) []string {
+
+	seen := make(map[string]bool)
+
+	tokens := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := tokens.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			continue
+		}
+
+		tok := tokens.Token()
+		if tok.Data != "a" {
+			continue
+		}
+
+		for _, attr := range tok.Attr {
+			if attr.Key != "href" {
+				continue
+			}
+
+			u, err := url.Parse(strings.TrimSpace(attr.Val))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				continue
+			}
+
+			// Fragments don't identify a different page.
+			u.Fragment = ""
+			link := u.String()
+
+			if prefix != "" && strings.HasPrefix(strings.ToLower(link), strings.ToLower(prefix)) {
+				continue
+			}
+			seen[link] = true
+		}
+	}
+
+	var links []string
+	for link := range seen {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	return links
+}
+
+// linkHeaderRel matches the webmention relationship within the value
+// of a HTTP "Link:" header.
+var linkHeaderRel = regexp.MustCompile(`(?i)^\s*<([^>]*)>\s*;.*\brel\s*=\s*"?[^"]*\bwebmention\b`)
+
+// DiscoverWebmentionEndpoint fetches the given target URL and returns
+// the webmention endpoint it advertises, if any.
+//
+// Endpoints may be advertised via a HTTP "Link:" header, or via a <link>
+// or <a> element with `rel="webmention"`.  If there is no endpoint then
+// the empty string is returned, without an error.
+func DiscoverWebmentionEndpoint(client *http.Client, target string) (string, error) {
+
+	resp, err := client.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fetching %s returned status %d", target, resp.StatusCode)
+	}
+
+	// Relative endpoints are resolved against the final URL,
+	// after any redirections.
+	base := resp.Request.URL
+
+	//
+	// Headers take priority.
+	//
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			m := linkHeaderRel.FindStringSubmatch(link)
+			if m != nil {
+				return resolveLink(base, m[1])
+			}
+		}
+	}
+
+	//
+	// Otherwise look at the body.
+	//
+	tokens := xhtml.NewTokenizer(io.LimitReader(resp.Body, maxWebmentionBody))
+	for {
+		tt := tokens.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			continue
+		}
+
+		tok := tokens.Token()
+		if tok.Data != "link" && tok.Data != "a" {
+			continue
+		}
+
+		rel := ""
+		href := ""
+		found := false
+		for _, attr := range tok.Attr {
+			switch attr.Key {
+			case "rel":
+				rel = attr.Val
+			case "href":
+				href = attr.Val
+				found = true
+			}
+		}
+
+		if found {
+			for _, r := range strings.Fields(strings.ToLower(rel)) {
+				if r == "webmention" {
+					return resolveLink(base, href)
+				}
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// resolveLink resolves a (possibly relative) link against a base URL.
+func resolveLink(base *url.URL, link string) (string, error) {
+	u, err := base.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// SendWebmention notifies the given endpoint that the source URL links
+// to the target URL.
+func SendWebmention(client *http.Client, endpoint string, source string, target string) error {
+
+	resp, err := client.PostForm(endpoint, url.Values{
+		"source": {source},
+		"target": {target},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webmention endpoint %s returned status %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// WebmentionState records the webmentions which have been sent, so that
+// we don't resend them each time a blog is rebuilt.
+type WebmentionState struct {
+	// path is the file the state is loaded from, and saved to.
+	path string
+
+	// Sent maps "source target" pairs to the time they were sent.
+	Sent map[string]time.Time
+}
+
+// LoadWebmentionState loads the state from the given file.
+//
+// A missing file is not an error, it results in an empty state.
+func LoadWebmentionState(path string) (*WebmentionState, error) {
+
+	state := &WebmentionState{path: path, Sent: make(map[string]time.Time)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+
+	err = json.Unmarshal(data, state)
+	if state.Sent == nil {
+		state.Sent = make(map[string]time.Time)
+	}
+	return state, err
+}
+
+// HasSent returns true if the webmention has already been sent.
+func (s *WebmentionState) HasSent(source string, target string) bool {
+	_, ok := s.Sent[source+" "+target]
+	return ok
+}
+
+// MarkSent records that the webmention has been sent.
+func (s *WebmentionState) MarkSent(source string, target string, when time.Time) {
+	s.Sent[source+" "+target] = when
+}
+
+// Save writes the state back to the file it was loaded from.
+func (s *WebmentionState) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// WebmentionReceiver is a HTTP handler which accepts incoming webmentions.
+//
+// Each mention is verified in the background, by fetching the source and
+// ensuring that it links to the target, before it is written to the
+// comment-directory as a comment-file for the target entry.  Mentions
+// which are resent are updated, and those whose source no longer links
+// to us are removed.
+//
+// Sources are only fetched from public addresses, so the receiver can't
+// be used to reach the hosts of a private network.
+type WebmentionReceiver struct {
+	// Client is used to fetch the source of each mention, its
+	// transport is replaced by one which only connects to public
+	// addresses.
+	Client *http.Client
+
+	// Prefix is the URL-prefix of the blog, only targets beneath
+	// this prefix are accepted.
+	Prefix string
+
+	// CommentsPath is the directory into which mentions are written.
+	CommentsPath string
+
+	// Notifier is used to send notifications of new mentions, if it
+	// is non-nil.
+	Notifier *Notifier
+
+	// Moderator is used to hold new mentions until they're approved,
+	// if it is non-nil.
+	Moderator *Moderator
+
+	// Site returns the blog, and mentions are only accepted upon
+	// those of its entries which accept comments.
+	//
+	// If this is nil no mentions are accepted.
+	Site func() (*Ephemeris, error)
+
+	// private allows sources upon non-public addresses, for testing.
+	private bool
+
+	// pending tracks the mentions which are being verified.
+	pending sync.WaitGroup
+}
+
+// entryName matches the names of our entries, beneath the prefix.
+var entryName = regexp.MustCompile(`^[a-z0-9_]+\.html$`)
+
+// ServeHTTP implements the http.Handler interface.
+func (w *WebmentionReceiver) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(rw, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source := r.FormValue("source")
+	target := strings.ToLower(r.FormValue("target"))
+
+	//
+	// Validate the URLs.
+	//
+	src, err := url.Parse(source)
+	if err != nil || (src.Scheme != "http" && src.Scheme != "https") || src.Host == "" {
+		http.Error(rw, "Invalid source", http.StatusBadRequest)
+		return
+	}
+	if source == target {
+		http.Error(rw, "The source and target must differ", http.StatusBadRequest)
+		return
+	}
+
+	prefix := strings.ToLower(w.Prefix)
+	if !strings.HasPrefix(target, prefix) {
+		http.Error(rw, "Invalid target", http.StatusBadRequest)
+		return
+	}
+
+	// The target must be one of our entries, which are named
+	// "${link}.html", beneath the prefix.
+	name := strings.TrimPrefix(target, prefix)
+	if !entryName.MatchString(name) {
+		http.Error(rw, "Invalid target", http.StatusBadRequest)
+		return
+	}
+
+	//
+	// The entry must exist, and accept comments.
+	//
+	open, err := commentsOpen(w.Site, name, time.Now())
+	if err != nil {
+		log.Printf("failed to load the site: %s", err.Error())
+		http.Error(rw, "Failed to store mention", http.StatusInternalServerError)
+		return
+	}
+	if !open {
+		http.Error(rw, "Comments are closed", http.StatusForbidden)
+		return
+	}
+
+	//
+	// The source must be somewhere we're willing to fetch.
+	//
+	err = w.checkHost(src.Hostname())
+	if err != nil {
+		http.Error(rw, "Invalid source", http.StatusBadRequest)
+		return
+	}
+
+	//
+	// Verify the mention in the background, so that we're not
+	// holding the request open while the source is fetched.
+	//
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+
+		err := w.verify(name, source, target)
+		if err != nil {
+			log.Printf("failed to verify webmention from %s: %s", source, err.Error())
+		}
+	}()
+
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(rw, "Webmention accepted for verification\n")
+}
+
+// verify fetches the source of a mention of the named entry, and stores
+// the mention if the source links to the target.  If it doesn't, any
+// mention previously stored from the source is removed.
+//
+// New mentions are held for moderation, and notified, in the same way
+// as new comments.
+func (w *WebmentionReceiver) verify(name string, source string, target string) error {
+
+	title, links, err := w.fetchSource(source, target)
+	if err != nil {
+		return err
+	}
+
+	existing, err := w.findMention(name, source)
+	if err != nil {
+		return err
+	}
+
+	if !links {
+
+		// If the mention was previously accepted then the
+		// source has been updated, so delete it.
+		if existing != "" {
+			return os.Remove(existing)
+		}
+		return fmt.Errorf("%s does not link to %s", source, target)
+	}
+
+	// Resent mentions are updated where they are, whether they've
+	// been approved or not, and aren't notified again.
+	if existing != "" {
+		_, err = writeWebmention("", name, existing, source, title)
+		return err
+	}
+
+	// Mentions awaiting moderation are written elsewhere.
+	dir := w.CommentsPath
+	if w.Moderator != nil {
+		dir = w.Moderator.PendingPath
+	}
+
+	file, err := writeWebmention(dir, name, "", source, title)
+	if err != nil {
+		return err
+	}
+
+	if w.Notifier != nil {
+		notifyComment(w.Notifier, w.Moderator, NewComment{
+			File:   file,
+			Entry:  w.Prefix + name,
+			Author: mentionAuthor(source),
+			Link:   source,
+			Body:   "Mentioned in " + title,
+		})
+	}
+	return nil
+}
+
+// findMention returns the path to the comment-file holding the mention of
+// the named entry from the given source, whether it has been approved or
+// is awaiting moderation.
+func (w *WebmentionReceiver) findMention(name string, source string) (string, error) {
+
+	existing, err := findWebmention(w.CommentsPath, name, source)
+	if err != nil || existing != "" || w.Moderator == nil {
+		return existing, err
+	}
+	return findWebmention(w.Moderator.PendingPath, name, source)
+}
+
+// nonPublic holds the private, and otherwise reserved, networks which
+// aren't covered by the methods of net.IP.
+var nonPublic = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"240.0.0.0/4",
+	"fc00::/7",
+)
+
+// parseNetworks parses the given CIDR networks.
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// publicAddress returns true if the given address is a public one, rather
+// than a loopback, private, link-local, or otherwise reserved address.
+func publicAddress(ip net.IP) bool {
+
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, network := range nonPublic {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHost returns an error if the given host has any address which isn't
+// a public one.
+func (w *WebmentionReceiver) checkHost(host string) error {
+
+	if w.private {
+		return nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if !publicAddress(ip) {
+			return fmt.Errorf("%s has the non-public address %s", host, ip)
+		}
+	}
+	return nil
+}
+
+// checkDial returns an error if we're about to connect to an address which
+// isn't a public one.  The host was checked before the mention was
+// accepted, but checking again here means that neither redirections nor
+// changes to the DNS can lead us elsewhere.
+func (w *WebmentionReceiver) checkDial(network string, address string, _ syscall.RawConn) error {
+
+	if w.private {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !publicAddress(ip) {
+		return fmt.Errorf("refusing to connect to the non-public address %s", host)
+	}
+	return nil
+}
+
+// client returns the client used to fetch sources, which only connects to
+// public addresses.
+func (w *WebmentionReceiver) client() *http.Client {
+
+	client := http.Client{Timeout: 30 * time.Second}
+	if w.Client != nil {
+		client = *w.Client
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: w.checkDial}
+	client.Transport = &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+	return &client
+}
+
+// fetchSource fetches the source of a webmention, returning its title and
+// whether it contains a link to the target.
+func (w *WebmentionReceiver) fetchSource(source string, target string) (string, bool, error) {
+
+	resp, err := w.client().Get(source)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	// A source which has gone away doesn't link to anything.
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", false, fmt.Errorf("fetching %s returned status %d", source, resp.StatusCode)
+	}
+
+	title := ""
+	inTitle := false
+	links := false
+
+	tokens := xhtml.NewTokenizer(io.LimitReader(resp.Body, maxWebmentionBody))
+	for {
+		tt := tokens.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+
+		tok := tokens.Token()
+		switch tt {
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if tok.Data == "title" {
+				inTitle = true
+			}
+			for _, attr := range tok.Attr {
+				if (attr.Key == "href" || attr.Key == "src") && strings.ToLower(strings.TrimSpace(attr.Val)) == target {
+					links = true
+				}
+			}
+		case xhtml.EndTagToken:
+			if tok.Data == "title" {
+				inTitle = false
+			}
+		case xhtml.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(tok.Data)
+			}
+		}
+	}
+
+	return title, links, nil
+}
+
+// findWebmention returns the path to the comment-file holding the mention
+// of the named entry from the given source, if there is one.
+func findWebmention(dir string, name string, source string) (string, error) {
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), name+".") {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		headers, err := headerfile.New(path).Headers()
+		if err != nil {
+			continue
+		}
+		if headers["type"] == WebmentionType && headers["link"] == source {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// mentionAuthor returns the author of a mention, which is the site the
+// mention came from.
+func mentionAuthor(source string) string {
+	if u, err := url.Parse(source); err == nil {
+		return u.Host
+	}
+	return source
+}
+
+// writeWebmention writes a received mention of the named entry to a
+// comment-file, replacing the existing file if there is one, and returns
+// the path to the file.
+func writeWebmention(dir string, name string, existing string, source string, title string) (string, error) {
+
+	if title == "" {
+		title = source
+	}
+
+	// Newlines would break our headers.
+	clean := strings.NewReplacer("\r", "", "\n", " ")
+
+	content := "Name: " + clean.Replace(mentionAuthor(source)) + "\n" +
+		"Link: " + clean.Replace(source) + "\n" +
+		"Type: " + WebmentionType + "\n" +
+		"\n" +
+		"<p>Mentioned in <a href=\"" + html.EscapeString(source) + "\">" + html.EscapeString(title) + "</a>.</p>\n"
+
+	if existing != "" {
+		return existing, os.WriteFile(existing, []byte(content), 0644)
+	}
+
+	return writeCommentFile(dir, name, content)
+}
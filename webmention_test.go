@@ -0,0 +1,249 @@
+package ephemeris
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test we find the outbound links in some content.
+func TestOutboundLinks(t *testing.T) {
+
+	content := `<p><a href="https://example.com/">one</a>
+<a href="https://example.com/#frag">again</a>
+<a href="https://blog.example.net/other.html">internal</a>
+<a href="/relative">relative</a>
+<a href="mailto:steve@example.com">mail</a>
+<a href="http://example.org/two">two</a></p>`
+
+	links := OutboundLinks(content, "https://blog.example.net/")
+	if len(links) != 2 {
+		t.Fatalf("unexpected links: %v", links)
+	}
+	if links[0] != "http://example.org/two" || links[1] != "https://example.com/" {
+		t.Errorf("unexpected links: %v", links)
+	}
+}
+
+// Test discovering webmention endpoints.
+func TestDiscoverWebmentionEndpoint(t *testing.T) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://example.com/>; rel="me", </endpoint/header>; rel="webmention"`)
+		fmt.Fprintf(w, "<html></html>")
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><link rel="webmention" href="endpoint/link"></head></html>`)
+	})
+	mux.HandleFunc("/anchor", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body><a rel="nofollow webmention" href="https://example.com/endpoint">x</a></body></html>`)
+	})
+	mux.HandleFunc("/none", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body><a href="https://example.com/">x</a></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := map[string]string{
+		"/header": server.URL + "/endpoint/header",
+		"/link":   server.URL + "/endpoint/link",
+		"/anchor": "https://example.com/endpoint",
+		"/none":   "",
+	}
+
+	for path, expected := range tests {
+		endpoint, err := DiscoverWebmentionEndpoint(server.Client(), server.URL+path)
+		if err != nil {
+			t.Fatalf("unexpected error discovering %s: %s", path, err.Error())
+		}
+		if endpoint != expected {
+			t.Errorf("unexpected endpoint for %s: '%s' != '%s'", path, endpoint, expected)
+		}
+	}
+
+	_, err := DiscoverWebmentionEndpoint(server.Client(), server.URL+"/missing")
+	if err == nil {
+		t.Errorf("expected an error discovering a missing page")
+	}
+}
+
+// Test sending a webmention.
+func TestSendWebmention(t *testing.T) {
+
+	var got url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	err := SendWebmention(server.Client(), server.URL, "https://a.example.com/", "https://b.example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if got.Get("source") != "https://a.example.com/" || got.Get("target") != "https://b.example.com/" {
+		t.Errorf("unexpected form submitted: %v", got)
+	}
+}
+
+// Test the sent-state is saved and restored.
+func TestWebmentionState(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := LoadWebmentionState(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading missing state: %s", err.Error())
+	}
+	if s.HasSent("a", "b") {
+		t.Errorf("empty state has a sent mention")
+	}
+
+	s.MarkSent("a", "b", time.Now())
+	err = s.Save()
+	if err != nil {
+		t.Fatalf("unexpected error saving state: %s", err.Error())
+	}
+
+	s, err = LoadWebmentionState(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading state: %s", err.Error())
+	}
+	if !s.HasSent("a", "b") || s.HasSent("b", "a") {
+		t.Errorf("state wasn't restored: %v", s.Sent)
+	}
+}
+
+// Test receiving webmentions.
+func TestWebmentionReceiver(t *testing.T) {
+
+	target := "https://example.com/my_first_post.html"
+	linked := true
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if linked {
+			fmt.Fprintf(w, `<html><head><title>A reply</title></head><body><a href="%s">Steve</a></body></html>`, target)
+		} else {
+			fmt.Fprintf(w, `<html><head><title>A reply</title></head><body>Nothing</body></html>`)
+		}
+	}))
+	defer source.Close()
+
+	dir := t.TempDir()
+	receiver := &WebmentionReceiver{
+		Client:       source.Client(),
+		Prefix:       "https://example.com/",
+		CommentsPath: dir,
+		Site:         testCommentSite(),
+		private:      true,
+	}
+
+	send := func(src string, tgt string) int {
+		req := httptest.NewRequest("POST", "/webmention", strings.NewReader(url.Values{"source": {src}, "target": {tgt}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		receiver.ServeHTTP(rec, req)
+		receiver.pending.Wait()
+		return rec.Code
+	}
+
+	// Bogus targets are rejected.
+	for _, tgt := range []string{"https://example.org/", "https://example.com/../../etc/passwd", "https://example.com/tags/"} {
+		if code := send(source.URL, tgt); code != http.StatusBadRequest {
+			t.Errorf("expected target %s to be rejected, got %d", tgt, code)
+		}
+	}
+
+	// As are entries which don't exist, or don't accept comments.
+	for _, tgt := range []string{"https://example.com/missing.html", "https://example.com/old_post.html"} {
+		if code := send(source.URL, tgt); code != http.StatusForbidden {
+			t.Errorf("expected target %s to be refused, got %d", tgt, code)
+		}
+	}
+
+	// A valid mention is stored.
+	if code := send(source.URL, target); code != http.StatusAccepted {
+		t.Fatalf("expected mention to be accepted, got %d", code)
+	}
+
+	// Resending updates, rather than duplicating.
+	if code := send(source.URL, target); code != http.StatusAccepted {
+		t.Fatalf("expected mention to be accepted, got %d", code)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected one comment-file, found %d", len(files))
+	}
+
+	c, err := NewBlogComment(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatalf("unexpected error reading mention %s", err.Error())
+	}
+	if c.Type != WebmentionType || c.Link != source.URL || !strings.Contains(c.Body, "A reply") {
+		t.Errorf("unexpected comment from mention: %v", c)
+	}
+
+	// The mention is attached to the right entry.
+	site, err := New("_test/blog_entry/1.txt", dir, "https://example.com/")
+	if err != nil {
+		t.Fatalf("error creating site: %s", err.Error())
+	}
+	if len(site.Entries()) != 1 || len(site.Entries()[0].CommentData) != 1 {
+		t.Errorf("mention wasn't attached to the entry")
+	}
+
+	// Once the source stops linking to us the mention is removed.
+	linked = false
+	if code := send(source.URL, target); code != http.StatusAccepted {
+		t.Errorf("expected mention to be accepted, got %d", code)
+	}
+	files, _ = os.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("expected the mention to be deleted, found %d files", len(files))
+	}
+
+	// Sources upon private addresses are never fetched.
+	receiver.private = false
+	if code := send(source.URL, target); code != http.StatusBadRequest {
+		t.Errorf("expected a private source to be rejected, got %d", code)
+	}
+	if err := receiver.verify("my_first_post.html", source.URL, target); err == nil {
+		t.Errorf("expected fetching a private source to fail")
+	}
+}
+
+// Test which addresses are considered public.
+func TestPublicAddress(t *testing.T) {
+
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"0.0.0.0":          false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+	}
+
+	for addr, public := range tests {
+		if publicAddress(net.ParseIP(addr)) != public {
+			t.Errorf("expected %s public=%t", addr, public)
+		}
+	}
+}